@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is what a config file may say, keyed on the names of the flags
+// without the dash. Whatever it leaves out is nil, and left to the command
+// line and the defaults of the flags.
+type Config struct {
+	Workers *int `json:"workers"`
+
+	// The ports to scan.
+	Ports *configList `json:"ports"`
+	TopPorts *int `json:"top-ports"`
+
+	// How long to wait for replies.
+	ARPTimeout *duration `json:"arp-timeout"`
+	Timeout *duration `json:"timeout"`
+	MaxRTTTimeout *duration `json:"max-rtt-timeout"`
+	IdleHosts *int `json:"idle-hosts"`
+	IdleTimeout *duration `json:"idle-timeout"`
+	HostTimeout *duration `json:"host-timeout"`
+	BannerTimeout *duration `json:"banner-timeout"`
+	PcapBuffer *int `json:"pcap-buffer"`
+	ReadTimeout *duration `json:"read-timeout"`
+
+	// How hard to try getting them.
+	Retries *int `json:"retries"`
+	Count *int `json:"count"`
+	Retransmit *duration `json:"retransmit"`
+	ARPMaxBackoff *duration `json:"arp-max-backoff"`
+	ARPCacheTTL *duration `json:"arp-cache-ttl"`
+
+	// What the probes look like.
+	ScanType *string `json:"scan-type"`
+	TTL *int `json:"ttl"`
+	Sport *int `json:"sport"`
+	IPID *int `json:"ipid"`
+	Window *int `json:"window"`
+	MSS *int `json:"mss"`
+	DF *bool `json:"df"`
+	Fragment *bool `json:"fragment"`
+	NoChecksum *bool `json:"no-checksum"`
+
+	// How hosts are checked, and how the ports are scanned.
+	Ping *bool `json:"ping"`
+	Pn *bool `json:"Pn"`
+	Connect *bool `json:"connect"`
+	SkipTarpits *bool `json:"skip-tarpits"`
+	Reset *bool `json:"reset"`
+	TLS *bool `json:"tls"`
+	HTTP *bool `json:"http"`
+	MaxDials *int `json:"max-dials"`
+	Rate *int `json:"rate"`
+
+	// How the results get written out.
+	Format *string `json:"format"`
+	JSONPretty *bool `json:"json-pretty"`
+	Output *string `json:"o"`
+	DB *string `json:"db"`
+	Open *bool `json:"open"`
+	Reason *bool `json:"reason"`
+
+	// Where the packets go out from.
+	Interface *string `json:"i"`
+	SourceIP *string `json:"source-ip"`
+	VLAN *int `json:"vlan"`
+
+	// Which hosts get scanned, and in which order.
+	SkipNetworkBroadcast *bool `json:"skip-network-broadcast"`
+	Exclude *configList `json:"exclude"`
+	MaxTargets *int `json:"max-targets"`
+	Only4 *bool `json:"4"`
+	Only6 *bool `json:"6"`
+	TargetFile *string `json:"iL"`
+	Randomize *bool `json:"randomize"`
+	Sample *int `json:"sample"`
+	Seed *int64 `json:"seed"`
+	Resume *string `json:"resume"`
+	DryRun *bool `json:"dry-run"`
+
+	// Everything else.
+	Metrics *string `json:"metrics"`
+	FailOn *string `json:"fail-on"`
+	Quiet *bool `json:"q"`
+	Verbose *bool `json:"v"`
+}
+
+// configList is a comma separated list, which a config file may have as a
+// string or as a list of strings and numbers, such as [22, "8000-8100"].
+type configList string
+
+// UnmarshalJSON : Reads a list as a string, or joins its items with commas.
+func (list *configList) UnmarshalJSON(data []byte) error {
+	var value any
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	items, ok := value.([]any)
+
+	if !ok {
+		items = []any{value}
+	}
+
+	parts := make([]string, len(items))
+
+	for i, item := range items {
+		switch item := item.(type) {
+		case string:
+			parts[i] = item
+		case float64:
+			parts[i] = strconv.FormatFloat(item, 'f', -1, 64)
+		default:
+			return errors.New("Expected a list of strings and numbers")
+		}
+	}
+
+	*list = configList(strings.Join(parts, ","))
+
+	return nil
+}
+
+// UnmarshalJSON : Reads a duration as a string such as "500ms", or as a
+// number of seconds, like the flags take.
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value any
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case string:
+		return d.Set(value)
+	case float64:
+		*d = duration(value * float64(time.Second))
+		return nil
+	}
+
+	return errors.New("Expected a duration or a number of seconds")
+}
+
+// describe : Says what a config file should have for a field of the type, for
+// the errors about the ones that have something else.
+func describe(t reflect.Type) string {
+	switch t {
+	case reflect.TypeFor[configList]():
+		return "a string or a list"
+	case reflect.TypeFor[duration]():
+		return `a duration such as "500ms", or a number of seconds`
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	}
+
+	return "a whole number"
+}
+
+// loadConfig : Reads the defaults of the flags from a JSON file into a Config,
+// such as {"ports": "22,2222", "rate": 1000}. Values have to be of the type of
+// their flag, and keys that aren't flags are errors, since they're most likely
+// typos. Flags given on the command line win over the file.
+func loadConfig(path string, flags *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	values := map[string]json.RawMessage{}
+
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+
+	// Every value goes into the field of its flag, which checks its type.
+	var config Config
+
+	fields := reflect.ValueOf(&config).Elem()
+	index := map[string]int{}
+
+	for i := 0; i < fields.NumField(); i++ {
+		index[fields.Type().Field(i).Tag.Get("json")] = i
+	}
+
+	for name, raw := range values {
+		i, ok := index[name]
+
+		if !ok {
+			return fmt.Errorf("Unknown option in config file %s: %q", path, name)
+		}
+
+		if err := json.Unmarshal(raw, fields.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("Invalid value for %q in config file %s: expected %s", name, path, describe(fields.Field(i).Type().Elem()))
+		}
+	}
+
+	// The flags from the command line are left alone.
+	given := map[string]bool{}
+
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, i := range index {
+		field := fields.Field(i)
+
+		if field.IsNil() || given[name] || flags.Lookup(name) == nil {
+			continue
+		}
+
+		// Durations read back the way Go writes them, everything else the way
+		// it's written out.
+		value := fmt.Sprint(field.Elem().Interface())
+
+		if stringer, ok := field.Interface().(fmt.Stringer); ok {
+			value = stringer.String()
+		}
+
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("Invalid value for %q in config file %s: %v", name, path, err)
+		}
+	}
+
+	return nil
+}
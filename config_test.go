@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig : Writes a config file into a temporary directory, returning its
+// path.
+func writeConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	flags := flag.NewFlagSet("shellscan", flag.ContinueOnError)
+	ports := flags.String("ports", "22", "")
+	workers := flags.Int("workers", 64, "")
+	verbose := flags.Bool("v", false, "")
+	timeout := new(time.Duration)
+	flags.Var((*duration)(timeout), "timeout", "")
+	flags.String("config", "", "")
+
+	// The command line says how many workers, the file says so too.
+	if err := flags.Parse([]string{"-workers", "8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, `{"ports": [22, "2222", "8000-8100"], "workers": 128, "v": true, "timeout": "500ms"}`)
+
+	if err := loadConfig(path, flags); err != nil {
+		t.Fatal(err)
+	}
+
+	if *ports != "22,2222,8000-8100" || *workers != 8 || !*verbose || *timeout != time.Millisecond * 500 {
+		t.Errorf("got ports %q, workers %d, v %v, timeout %v", *ports, *workers, *verbose, *timeout)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, c := range []struct {
+		config string
+		want string
+	}{
+		{`{"prots": "22"}`, `Unknown option in config file`},
+		{`{"config": "other.json"}`, `Unknown option in config file`},
+		{`{"workers": "many"}`, `Invalid value for "workers"`},
+		{`{"ports": {"ssh": 22}}`, `Invalid value for "ports"`},
+		{`{"workers": "8"}`, `Invalid value for "workers" in config file`},
+		{`{"workers": 2.5}`, `Invalid value for "workers"`},
+		{`{"v": "yes"}`, `Invalid value for "v"`},
+		{`{"timeout": "soon"}`, `Invalid value for "timeout"`},
+		{`{"timeout": [1]}`, `Invalid value for "timeout"`},
+		{`{"ports": [[22]]}`, `Invalid value for "ports"`},
+		{`["ports", "22"]`, `Invalid config file`},
+	} {
+		flags := flag.NewFlagSet("shellscan", flag.ContinueOnError)
+		flags.String("ports", "22", "")
+		flags.Int("workers", 64, "")
+		flags.Bool("v", false, "")
+		durationFlag(flags, "timeout", 0, "")
+		flags.String("config", "", "")
+
+		err := loadConfig(writeConfig(t, c.config), flags)
+
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", c.config, err, c.want)
+		}
+	}
+}
+
+// TestConfigOptions checks that the options of a run come from the config
+// file, unless the command line says otherwise.
+func TestConfigOptions(t *testing.T) {
+	path := writeConfig(t, `{"ports": [22, "2222"], "workers": 128, "timeout": 1.5, "rate": 1000, "connect": true, "format": "json", "exclude": "10.0.0.1"}`)
+	settings, err := parse("-config", path, "-workers", "8", "-format", "csv", "10.0.0.0/24")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(settings.Options.Ports, []uint16{22, 2222}) || settings.Options.ScanTimeout != time.Millisecond * 1500 || !settings.Options.Connect || settings.Options.Limiter == nil {
+		t.Errorf("got options %+v", settings.Options)
+	}
+
+	if settings.Workers != 8 || settings.Format != "csv" || !slices.Equal(settings.Exclude, []string{"10.0.0.1"}) {
+		t.Errorf("got workers %d, format %q, exclude %v", settings.Workers, settings.Format, settings.Exclude)
+	}
+}
+
+// TestConfigFields checks that Config has a field for every flag but -config,
+// and no field that isn't one.
+func TestConfigFields(t *testing.T) {
+	flags := flag.NewFlagSet("shellscan", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	if _, err := parseFlags(flags, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]bool{}
+	config := reflect.TypeFor[Config]()
+
+	for i := 0; i < config.NumField(); i++ {
+		name := config.Field(i).Tag.Get("json")
+		fields[name] = true
+
+		if flags.Lookup(name) == nil {
+			t.Errorf("config field %s is for -%s, which isn't a flag", config.Field(i).Name, name)
+		}
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if !fields[f.Name] && f.Name != "config" {
+			t.Errorf("no config field for -%s", f.Name)
+		}
+	})
+}
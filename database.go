@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+
+	// A SQLite driver that doesn't need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// Database writes scan results into a SQLite database, through a single
+// goroutine since SQLite only takes one writer at a time.
+type Database struct {
+	db *sql.DB
+
+	// The results of every host waiting to be written, and the channel that
+	// gets closed once they all are.
+	results chan []scanner.Result
+	done chan struct{}
+
+	// The first error writing the results ran into.
+	err error
+}
+
+// OpenDatabase : Opens (or creates) a SQLite database and the table the
+// results go into. Results are added to those of previous scans.
+func OpenDatabase(path string) (*Database, error) {
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS results (
+		ip TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		state TEXT NOT NULL,
+		banner TEXT NOT NULL,
+		rtt_ms REAL,
+		scanned_at TEXT NOT NULL,
+		hostname TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		error TEXT NOT NULL
+	)`)
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	database := &Database{
+		db: db,
+		results: make(chan []scanner.Result, 64),
+		done: make(chan struct{}),
+	}
+
+	go database.write()
+
+	return database, nil
+}
+
+// Write : Queues the results of a host to be written.
+func (database *Database) Write(results []scanner.Result) {
+	database.results <- append([]scanner.Result(nil), results...)
+}
+
+// write : Writes out the results as they're queued, those of a host in a
+// single transaction, until the queue is closed.
+func (database *Database) write() {
+	defer close(database.done)
+
+	for results := range database.results {
+		if database.err != nil {
+			continue
+		}
+
+		database.err = database.insert(results)
+	}
+}
+
+// insert : Adds the rows of the results to the table.
+func (database *Database) insert(results []scanner.Result) error {
+	tx, err := database.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, result := range results {
+		// Ports that didn't answer have no round-trip time.
+		var rtt sql.NullFloat64
+
+		if result.RTT > 0 {
+			rtt = sql.NullFloat64{Float64: float64(result.RTT) / float64(time.Millisecond), Valid: true}
+		}
+
+		// Hosts that couldn't be scanned have their ports filtered, with the
+		// error saying why.
+		_, err := tx.Exec("INSERT INTO results (ip, port, state, banner, rtt_ms, scanned_at, hostname, reason, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			result.IP, result.Port, result.State.String(), result.Banner, rtt, result.Time.UTC().Format(time.RFC3339Nano),
+			result.Hostname, result.Reason, result.Error)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close : Waits for the queued results to be written and closes the database,
+// returning the first error writing them ran into.
+func (database *Database) Close() error {
+	close(database.results)
+	<-database.done
+
+	if err := database.db.Close(); err != nil && database.err == nil {
+		database.err = err
+	}
+
+	return database.err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// row is a row of the results table, as read back.
+type row struct {
+	ip string
+	port int
+	state string
+	banner string
+	rtt sql.NullFloat64
+	hostname string
+	reason string
+	err string
+}
+
+// readRows : Reads back every row of the results table of a database, in the
+// order they were written.
+func readRows(t *testing.T, path string) []row {
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	rows, err := db.Query("SELECT ip, port, state, banner, rtt_ms, hostname, reason, error FROM results ORDER BY rowid")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	got := []row{}
+
+	for rows.Next() {
+		var r row
+
+		if err := rows.Scan(&r.ip, &r.port, &r.state, &r.banner, &r.rtt, &r.hostname, &r.reason, &r.err); err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	database, err := OpenDatabase(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	database.Write([]scanner.Result{
+		{IP: "93.184.215.14", Port: 22, Hostname: "git.example.com", State: scanner.Open, Reason: "syn-ack", Banner: "SSH-2.0-OpenSSH_9.6", RTT: time.Microsecond * 1500, Time: now},
+		{IP: "93.184.215.14", Port: 2222, Hostname: "git.example.com", State: scanner.Closed, Reason: "reset", RTT: time.Millisecond, Time: now},
+	})
+
+	// A host that couldn't be scanned is told apart from one that didn't
+	// answer by its error.
+	database.Write(scanner.FailedResults(net.IP{10, 0, 0, 9}, []uint16{22}, fmt.Errorf("%w: no ARP reply within 3s", scanner.ErrNoNeighbor)))
+	database.Write([]scanner.Result{{IP: "10.0.0.1", Port: 22, State: scanner.Filtered, Reason: "no-response", Time: now}})
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []row{
+		{"93.184.215.14", 22, "open", "SSH-2.0-OpenSSH_9.6", sql.NullFloat64{Float64: 1.5, Valid: true}, "git.example.com", "syn-ack", ""},
+		{"93.184.215.14", 2222, "closed", "", sql.NullFloat64{Float64: 1, Valid: true}, "git.example.com", "reset", ""},
+		{"10.0.0.9", 22, "filtered", "", sql.NullFloat64{}, "", "arp-timeout", "Host wasn't found on the network: no ARP reply within 3s"},
+		{"10.0.0.1", 22, "filtered", "", sql.NullFloat64{}, "", "no-response", ""},
+	}
+
+	if got := readRows(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows\n%+v\nwant\n%+v", got, want)
+	}
+}
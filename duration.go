@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strconv"
+	"time"
+)
+
+// duration is a flag.Value for the timeout flags. It takes Go durations such
+// as "500ms" or "1m30s", as well as plain numbers of seconds such as "2.5",
+// which is all the flags used to take.
+type duration time.Duration
+
+// String : Returns the duration the way Go writes it.
+func (d *duration) String() string {
+	return time.Duration(*d).String()
+}
+
+// Set : Parses a duration, or a number of seconds.
+func (d *duration) Set(value string) error {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		*d = duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+
+	if err != nil {
+		return errors.New("Expected a duration such as 500ms or 2s, or a number of seconds")
+	}
+
+	*d = duration(parsed)
+
+	return nil
+}
+
+// durationFlag : Defines a flag taking a duration, like flag.Duration does, but
+// taking plain numbers of seconds too.
+func durationFlag(flags *flag.FlagSet, name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+
+	flags.Var((*duration)(p), name, usage)
+
+	return p
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want time.Duration
+		err bool
+	}{
+		{"500ms", time.Millisecond * 500, false},
+		{"2s", time.Second * 2, false},
+		{"1m30s", time.Second * 90, false},
+		{"2500us", time.Microsecond * 2500, false},
+		{"3", time.Second * 3, false},
+		{"2.5", time.Millisecond * 2500, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"fast", 0, true},
+		{"5 s", 0, true},
+		{"10parsecs", 0, true},
+	} {
+		flags := flag.NewFlagSet("shellscan", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+
+		d := time.Second
+		flags.Var((*duration)(&d), "timeout", "")
+
+		err := flags.Parse([]string{"-timeout", c.value})
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", c.value, d)
+			}
+
+			continue
+		}
+
+		if err != nil || d != c.want {
+			t.Errorf("%q: got %v, %v, want %v", c.value, d, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+	"golang.org/x/time/rate"
+)
+
+// Settings is what a run was asked to do by the command line and the config
+// file, once it's been checked.
+type Settings struct {
+	// The settings every scanner shares, and how many scans run at once.
+	Options scanner.Options
+	Workers int
+
+	// How the results get written out, and where to.
+	Format string
+	Pretty bool
+	OnlyOpen bool
+	Reason bool
+	Output string
+	Database string
+
+	// The targets given on the command line, and the file with more of them.
+	Targets []string
+	TargetFile string
+
+	// How the targets expand to hosts: which ones to leave out, how many
+	// there may be, which addresses of hostnames to scan (4 or 6, or 0 for
+	// all of them), and in which order.
+	SkipEdges bool
+	Exclude []string
+	MaxTargets int
+	Family int
+	Randomize bool
+	Sample int
+	Seed int64
+
+	// The output of a previous scan whose hosts to skip.
+	Resume string
+
+	// Whether to only show what would be scanned.
+	DryRun bool
+
+	// Where to serve metrics for Prometheus, if anywhere.
+	Metrics string
+
+	// Whether to exit with exitFound when open ports were found ("open") or
+	// when none were ("none").
+	FailOn string
+
+	// Whether to keep quiet about the progress and the totals.
+	Quiet bool
+}
+
+// parseFlags : Defines the flags on flags, parses the arguments along with the
+// config file they name, and checks what they say.
+func parseFlags(flags *flag.FlagSet, args []string) (*Settings, error) {
+	// Each worker holds a scanner and a queue of received packets while
+	// scanning, so this bounds the resources used by a scan.
+	workers := flags.Int("workers", 64, "Number of targets to scan concurrently")
+
+	// The ports we're looking for, which is just SSH unless told otherwise.
+	portList := flags.String("ports", "22", "Comma separated list of destination TCP ports and port ranges to scan")
+	topN := flags.Int("top-ports", 0, "Scan the N most common ports, up to 100, along with any given by -ports")
+
+	// How long to wait for replies, as durations or numbers of seconds.
+	arpTimeout := durationFlag(flags, "arp-timeout", scanner.DefaultTimeout, "How long to wait for an ARP reply (0 means the default)")
+	scanTimeout := durationFlag(flags, "timeout", scanner.DefaultTimeout, "How long to wait for replies to the probes (0 means the default)")
+	maxRTT := durationFlag(flags, "max-rtt-timeout", 0, "Wait for replies based on measured round-trip times, but at most this long (0 means always wait -timeout)")
+	idleHosts := flags.Int("idle-hosts", 0, "Shorten the wait for replies to -idle-timeout after this many hosts in a row didn't answer (0 means never)")
+	idleTimeout := durationFlag(flags, "idle-timeout", time.Millisecond * 500, "How long to wait for replies once -idle-hosts hosts in a row didn't answer")
+	hostTimeout := durationFlag(flags, "host-timeout", 0, "Most time to spend on a single host, ARP included (0 means no limit)")
+	bannerTimeout := durationFlag(flags, "banner-timeout", scanner.DefaultBannerTimeout, "How long services get to send their banner (0 means the default)")
+	pcapBuffer := flags.Int("pcap-buffer", 0, "Size of the PCAP capture buffer in MB (0 means libpcap's default)")
+	readTimeout := durationFlag(flags, "read-timeout", scanner.DefaultReadTimeout, "Most time a PCAP read may block while waiting for packets (0 means the default)")
+
+	// How hard to try getting those replies.
+	retries := flags.Int("retries", 2, "How many times to resend ARP requests and probes that got no reply")
+	count := flags.Int("count", 1, "How many probes to send every port, counting how many get a reply")
+	retransmit := durationFlag(flags, "retransmit", scanner.DefaultRetransmit, "How long to wait for a reply before resending (0 means the default)")
+	maxBackoff := durationFlag(flags, "arp-max-backoff", scanner.DefaultMaxBackoff, "Most time to wait between ARP requests as they back off (0 means the default)")
+
+	// How long resolved network addresses are remembered.
+	macTTL := durationFlag(flags, "arp-cache-ttl", time.Minute, "How long to remember addresses resolved by ARP (0 disables the cache)")
+
+	// What the probes look like.
+	scanTypeName := flags.String("scan-type", "syn", "Kind of probes to send: syn, fin, null or xmas")
+	ttl := flags.Int("ttl", scanner.DefaultTTL, "TTL (or IPv6 hop limit) of the probes, 1 to 255")
+	sport := flags.Int("sport", 0, "Source port of the probes, 1024 to 65535 (0 means random)")
+	ipid := flags.Int("ipid", -1, "IPv4 ID of the probes, 0 to 65535 (-1 means random for every probe)")
+	window := flags.Int("window", scanner.DefaultWindow, "TCP window of the probes, 1 to 65535")
+	mss := flags.Int("mss", 0, "MSS option to send with the probes, 1 to 65535 (0 means none)")
+	dontFragment := flags.Bool("df", true, "Set the Don't Fragment bit of IPv4 probes")
+	fragment := flags.Bool("fragment", false, "Split IPv4 probes into 8 byte fragments")
+	noChecksum := flags.Bool("no-checksum", false, "Leave the checksums of the packets for the NIC to compute")
+
+	// Whether to check that hosts are up before probing them.
+	ping := flags.Bool("ping", false, "Only scan the hosts that answer a ping, or ARP on the local network")
+	skipDiscovery := flags.Bool("Pn", false, "Scan every host, even ones on the local network that don't answer ARP")
+
+	// Whether to connect to the ports instead, which works without root.
+	connect := flags.Bool("connect", false, "Scan by connecting to the ports instead of sending raw probes (used anyway without permission to capture)")
+
+	// What to do with the open ports once they answer.
+	skipTarpits := flags.Bool("skip-tarpits", false, "Don't grab the banners of ports that look like tarpits")
+	reset := flags.Bool("reset", false, "Answer the SYN/ACKs of open ports with a RST")
+	useTLS := flags.Bool("tls", false, "Grab the banners of every open port over TLS, not just the ports that usually speak it")
+	useHTTP := flags.Bool("http", false, "Grab banners by sending open ports an HTTP HEAD request")
+
+	// How many connections can be open at once, across all workers.
+	maxDials := flags.Int("max-dials", 256, "Most connections to have open at once to grab banners (0 means unlimited)")
+
+	// How fast to send packets, across all workers.
+	packetRate := flags.Int("rate", 0, "Maximum packets per second to send (0 means unlimited)")
+
+	// How the results get written out.
+	format := flags.String("format", "plain", "Output format, either plain, json, json-array or csv")
+	pretty := flags.Bool("json-pretty", false, "Indent the objects of the json formats")
+	outputPath := flags.String("o", "", "File to write the results to instead of stdout")
+	dbPath := flags.String("db", "", "SQLite database to write the results into as well")
+	onlyOpen := flags.Bool("open", false, "Only write out the ports that are open")
+	reason := flags.Bool("reason", false, "Write out why every port got its state")
+
+	// The interface to send packets out of, if the routing table picks the
+	// wrong one.
+	ifaceName := flags.String("i", "", "Interface to scan from, bypassing the routing table")
+	sourceIP := flags.String("source-ip", "", "IPv4 address to send probes from instead of the interface's, replies to which have to find their own way back")
+
+	// The VLAN to tag packets with, if the interface carries tagged traffic.
+	vlan := flags.Int("vlan", 0, "802.1Q VLAN ID to tag packets with, 1 to 4094 (0 means untagged)")
+
+	// Whether CIDR blocks include their network and broadcast addresses.
+	skipEdges := flags.Bool("skip-network-broadcast", true, "Leave the network and broadcast addresses of IPv4 blocks out")
+
+	// How many hosts a scan may be for, so a typo doesn't start a scan of the
+	// whole internet.
+	exclude := flags.String("exclude", "", "Comma separated list of IPs, IP nets and IP ranges to leave out of the targets")
+	maxTargets := flags.Int("max-targets", 65536, "Most hosts to scan, past which the scan doesn't start (0 means no limit)")
+
+	// Which addresses of hostnames to scan.
+	only4 := flags.Bool("4", false, "Only scan the IPv4 addresses of hostnames")
+	only6 := flags.Bool("6", false, "Only scan the IPv6 addresses of hostnames")
+
+	// A file with more targets in it.
+	inputList := flags.String("iL", "", "File to read targets from, one per line (- for stdin)")
+
+	// Whether to scan the targets in a random order, and which one.
+	randomize := flags.Bool("randomize", false, "Scan the targets in a random order")
+	sample := flags.Int("sample", 0, "Scan this many hosts picked at random out of the targets (0 means all of them)")
+	seed := flags.Int64("seed", 0, "Seed for -randomize and -sample, for the same hosts in the same order every time (0 means different ones every run)")
+
+	// The output of a scan that got cut short, to pick up where it left off.
+	resumePath := flags.String("resume", "", "Output of a previous scan whose hosts to skip, in any format")
+
+	// Whether to only show what would be scanned.
+	dry := flags.Bool("dry-run", false, "Print the targets and the interface and source address of each, without scanning")
+
+	// Where to serve metrics for Prometheus, if anywhere.
+	metricsAddr := flags.String("metrics", "", "Address to serve Prometheus metrics on, such as :9090")
+
+	// Whether to exit with an error depending on what was found, for failing
+	// pipelines.
+	failOn := flags.String("fail-on", "", "Exit with status 1 if open ports were found (open) or if none were (none)")
+
+	// Whether to keep quiet about the totals.
+	quiet := flags.Bool("q", false, "Don't print the progress and a summary to stderr")
+
+	// Whether to log what's going on in detail.
+	verbose := flags.Bool("v", false, "Log debugging output to stderr")
+
+	// Where to read the defaults of all of the above from.
+	configPath := flags.String("config", "", "JSON file to read the defaults of the flags from, keyed on flag names")
+
+	// Parse all command line arguments, which should just be IPs.
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Whatever the command line doesn't say, the config file may.
+	if *configPath != "" {
+		if err := loadConfig(*configPath, flags); err != nil {
+			return nil, err
+		}
+	}
+
+	// Which flags were given, rather than left to their defaults.
+	given := map[string]bool{}
+
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if *workers < 1 {
+		return nil, errors.New("-workers must be at least 1")
+	}
+
+	// The most common ports replace the default ones, or are scanned along
+	// with the ones asked for.
+	list := *portList
+
+	if *topN < 0 {
+		return nil, errors.New("-top-ports can't be negative")
+	}
+
+	if *topN > 0 {
+		list = topPortList(*topN)
+
+		if given["ports"] {
+			list = *portList + "," + list
+		}
+	}
+
+	ports, err := parsePorts(list)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if *arpTimeout < 0 || *scanTimeout < 0 || *maxRTT < 0 || *hostTimeout < 0 || *retransmit < 0 || *maxBackoff < 0 || *macTTL < 0 || *readTimeout < 0 || *idleTimeout < 0 || *bannerTimeout < 0 {
+		return nil, errors.New("timeouts can't be negative")
+	}
+
+	if *count < 1 {
+		return nil, errors.New("-count must be at least 1")
+	}
+
+	if *idleHosts < 0 {
+		return nil, errors.New("-idle-hosts can't be negative")
+	}
+
+	if *retries < 0 {
+		return nil, errors.New("-retries can't be negative")
+	}
+
+	scanType, err := scanner.ParseScanType(*scanTypeName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if *connect && scanType != scanner.SYNScan {
+		return nil, errors.New("-connect only works with the syn scan type")
+	}
+
+	if *ping && *skipDiscovery {
+		return nil, errors.New("-ping doesn't work with -Pn")
+	}
+
+	// Fragments can't have the Don't Fragment bit, so it's only cleared for
+	// them unless it was asked for.
+	if *fragment && *connect {
+		return nil, errors.New("-fragment doesn't work with -connect")
+	}
+
+	if *fragment && given["df"] && *dontFragment {
+		return nil, errors.New("-fragment doesn't work with -df")
+	}
+
+	if *ttl < 1 || *ttl > 255 {
+		return nil, errors.New("-ttl must be between 1 and 255")
+	}
+
+	if *window < 1 || *window > 65535 {
+		return nil, errors.New("-window must be between 1 and 65535")
+	}
+
+	if *mss < 0 || *mss > 65535 {
+		return nil, errors.New("-mss must be between 1 and 65535, or 0")
+	}
+
+	if *pcapBuffer < 0 {
+		return nil, errors.New("-pcap-buffer can't be negative")
+	}
+
+	if *sport != 0 && (*sport < 1024 || *sport > 65535) {
+		return nil, errors.New("-sport must be between 1024 and 65535, or 0")
+	}
+
+	if *ipid < -1 || *ipid > 65535 {
+		return nil, errors.New("-ipid must be between 0 and 65535, or -1")
+	}
+
+	family := 0
+
+	switch {
+	case *only4 && *only6:
+		return nil, errors.New("-4 and -6 can't be used together")
+	case *only4:
+		family = 4
+	case *only6:
+		family = 6
+	}
+
+	var source net.IP
+
+	if *sourceIP != "" {
+		if source = net.ParseIP(*sourceIP).To4(); source == nil {
+			return nil, errors.New("-source-ip must be an IPv4 address")
+		}
+
+		if *connect {
+			return nil, errors.New("-source-ip doesn't work with -connect")
+		}
+	}
+
+	if *sample < 0 {
+		return nil, errors.New("-sample can't be negative")
+	}
+
+	if *maxTargets < 0 {
+		return nil, errors.New("-max-targets can't be negative")
+	}
+
+	if *failOn != "" && *failOn != "open" && *failOn != "none" {
+		return nil, errors.New("-fail-on must be either open or none")
+	}
+
+	if *pretty && *format != "json" && *format != "json-array" {
+		return nil, errors.New("-json-pretty only works with the json formats")
+	}
+
+	if *vlan < 0 || *vlan > 4094 {
+		return nil, errors.New("-vlan must be between 1 and 4094, or 0")
+	}
+
+	if *maxDials < 0 {
+		return nil, errors.New("-max-dials can't be negative")
+	}
+
+	if *packetRate < 0 {
+		return nil, errors.New("-rate can't be negative")
+	}
+
+	if *ifaceName != "" {
+		if _, err := net.InterfaceByName(*ifaceName); err != nil {
+			return nil, err
+		}
+	}
+
+	// Diagnostics go to stderr, so that stdout only has results on it. Unless
+	// asked for, only the things that actually went wrong show up.
+	level := slog.LevelWarn
+
+	if *verbose {
+		level = slog.LevelDebug
+	}
+
+	settings := &Settings{
+		Options: scanner.Options{
+			Ports: ports,
+			ARPTimeout: *arpTimeout,
+			ScanTimeout: *scanTimeout,
+			HostTimeout: *hostTimeout,
+			BannerTimeout: *bannerTimeout,
+			ReadTimeout: *readTimeout,
+			BufferSize: *pcapBuffer * 1024 * 1024,
+			ScanType: scanType,
+			Connect: *connect,
+			TLS: *useTLS,
+			Reset: *reset,
+			SkipTarpits: *skipTarpits,
+			Ping: *ping,
+			SkipDiscovery: *skipDiscovery,
+			HTTP: *useHTTP,
+			TTL: uint8(*ttl),
+			IPID: uint16(*ipid),
+			FixedIPID: *ipid >= 0,
+			AllowFragments: !*dontFragment,
+			Fragment: *fragment,
+			SourcePort: uint16(*sport),
+			Window: uint16(*window),
+			MSS: uint16(*mss),
+			NoChecksums: *noChecksum,
+			Retries: *retries,
+			RetransmitInterval: *retransmit,
+			MaxBackoff: *maxBackoff,
+			Count: *count,
+			Interface: *ifaceName,
+			SourceIP: source,
+			VLAN: uint16(*vlan),
+			Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})),
+		},
+		Workers: *workers,
+		Format: *format,
+		Pretty: *pretty,
+		OnlyOpen: *onlyOpen,
+		Reason: *reason,
+		Output: *outputPath,
+		Database: *dbPath,
+		Targets: flags.Args(),
+		TargetFile: *inputList,
+		SkipEdges: *skipEdges,
+		MaxTargets: *maxTargets,
+		Family: family,
+		Randomize: *randomize,
+		Sample: *sample,
+		Seed: *seed,
+		Resume: *resumePath,
+		DryRun: *dry,
+		Metrics: *metricsAddr,
+		FailOn: *failOn,
+		Quiet: *quiet,
+	}
+
+	if *exclude != "" {
+		settings.Exclude = strings.Split(*exclude, ",")
+	}
+
+	// The workers share the addresses they resolve, so hosts behind the same
+	// gateway only ARP for it once.
+	if *macTTL > 0 {
+		settings.Options.MACCache = scanner.NewMACCache(*macTTL)
+	}
+
+	// And the round-trip times, so that every host gets waited on for about
+	// as long as the hosts before it took to answer.
+	if *maxRTT > 0 {
+		settings.Options.RTT = scanner.NewRTTEstimator(*maxRTT)
+	}
+
+	// And whether the hosts have stopped answering, so that a dead range
+	// doesn't get waited on host after host.
+	if *idleHosts > 0 {
+		settings.Options.Idle = scanner.NewIdleDetector(*idleHosts, *idleTimeout)
+	}
+
+	// And the connection slots, so a big scan doesn't run out of ports.
+	if *maxDials > 0 {
+		settings.Options.Dials = scanner.NewDialLimiter(*maxDials)
+	}
+
+	// A single limiter is shared by every worker, so the rate holds for the
+	// scan as a whole.
+	if *packetRate > 0 {
+		settings.Options.Limiter = rate.NewLimiter(rate.Limit(*packetRate), 1)
+	}
+
+	return settings, nil
+}
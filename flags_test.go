@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// parse : Parses the arguments like the command line would be.
+func parse(args ...string) (*Settings, error) {
+	flags := flag.NewFlagSet("shellscan", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	return parseFlags(flags, args)
+}
+
+func TestParseFlags(t *testing.T) {
+	settings, err := parse("-ports", "22,2222", "-top-ports", "3", "-timeout", "500ms", "-randomize", "-exclude", "10.0.0.1,10.0.0.8/29", "-4", "-arp-cache-ttl", "0", "10.0.0.0/24", "git.example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ports asked for go along with the most common ones.
+	if want := []uint16{22, 23, 80, 443, 2222}; !slices.Equal(settings.Options.Ports, want) {
+		t.Errorf("got ports %v, want %v", settings.Options.Ports, want)
+	}
+
+	if settings.Options.ScanTimeout != time.Millisecond * 500 || settings.Options.ScanType != scanner.SYNScan || settings.Options.MACCache != nil {
+		t.Errorf("got options %+v", settings.Options)
+	}
+
+	if !settings.Randomize || settings.Family != 4 || !slices.Equal(settings.Exclude, []string{"10.0.0.1", "10.0.0.8/29"}) {
+		t.Errorf("got settings %+v", settings)
+	}
+
+	if !slices.Equal(settings.Targets, []string{"10.0.0.0/24", "git.example.com"}) {
+		t.Errorf("got targets %v", settings.Targets)
+	}
+
+	// Flags that don't make sense, alone or together, are errors.
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-workers", "0"}, "-workers must be at least 1"},
+		{[]string{"-ttl", "256"}, "-ttl must be between 1 and 255"},
+		{[]string{"-4", "-6"}, "-4 and -6 can't be used together"},
+		{[]string{"-fragment", "-df"}, "-fragment doesn't work with -df"},
+		{[]string{"-json-pretty", "-format", "csv"}, "-json-pretty only works with the json formats"},
+		{[]string{"-source-ip", "fd00::1"}, "-source-ip must be an IPv4 address"},
+	} {
+		if _, err := parse(c.args...); err == nil || err.Error() != c.want {
+			t.Errorf("%v: got %v, want %q", c.args, err, c.want)
+		}
+	}
+}
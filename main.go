@@ -1,149 +1,589 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/pcap"
+	"github.com/add1ct3d/shellscan/scanner"
 	"github.com/google/gopacket/routing"
 )
 
-// create : Initialize a new scanner that will scan our target IP address.
-func create(ip net.IP, router routing.Router) (*SSHScanner, error) {
-	// Initialize a new SSHScanner.
-	sshScanner := &SSHScanner{
-		// Set the destination IP.
-		DestIP: ip,
+// parsePorts : Parses a comma separated list of ports and ranges of ports, such
+// as "22,2222,8000-8100", into a sorted list with every port in it once.
+func parsePorts(list string) ([]uint16, error) {
+	seen := make(map[uint16]struct{})
 
-		// And set the helper options and buffer.
-		Buffer: gopacket.NewSerializeBuffer(),
-		Options: gopacket.SerializeOptions{
-			FixLengths: true,
-			ComputeChecksums: true,
-		},
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		start, end, isRange := strings.Cut(field, "-")
+
+		first, err := parsePort(start)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid port: %q", field)
+		}
+
+		last := first
+
+		if isRange {
+			if last, err = parsePort(end); err != nil {
+				return nil, fmt.Errorf("Invalid port range: %q", field)
+			}
+
+			if last < first {
+				return nil, fmt.Errorf("Port range ends before it starts: %q", field)
+			}
+		}
+
+		for port := int(first); port <= int(last); port++ {
+			seen[uint16(port)] = struct{}{}
+		}
 	}
 
-	// Figure out the route to the IP address of choice.
-	iface, gateway, src, err := router.Route(ip)
+	ports := make([]uint16, 0, len(seen))
 
-	if err != nil {
-		return nil, err
+	for port := range seen {
+		ports = append(ports, port)
 	}
 
-	sshScanner.Gateway = gateway
-	sshScanner.SourceIP = src
-	sshScanner.Interface = iface
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return ports, nil
+}
+
+// parsePort : Parses a single port, which can't be 0.
+func parsePort(field string) (uint16, error) {
+	port, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+
+	if err == nil && port == 0 {
+		err = fmt.Errorf("Invalid port: %q", field)
+	}
+
+	return uint16(port), err
+}
+
+// worker : Scans every IP it receives until the targets channel is closed. The
+// names are the hostnames the IPs were resolved from.
+func worker(ctx context.Context, targets <-chan net.IP, options scanner.Options, names map[string]string, printer *Printer, summary *Summary) {
+	for ip := range targets {
+		// Skip whatever is left in the queue once the scan has been cancelled.
+		if ctx.Err() != nil {
+			continue
+		}
+
+		scan(ctx, ip, options, names[ip.String()], printer, summary)
+	}
+}
 
-	// Open a PCAP handle for editing ops.
-	pcapHandle, err := pcap.OpenLive(iface.Name, 65536, true, pcap.BlockForever)
+// scan : Creates a scanner for a single IP, runs it and cleans up. The hostname
+// is the one the IP was resolved from, if it was.
+func scan(ctx context.Context, ip net.IP, options scanner.Options, hostname string, printer *Printer, summary *Summary) {
+	// Create a new scanner.
+	hostScanner, err := scanner.New(ip, options)
 
 	if err != nil {
-		return nil, err
+		options.Logger.Warn("Unable to create scanner", "ip", ip, "err", err)
+		summary.Fail()
+		return
+	}
+
+	// Stop the scanner once we're done with it.
+	defer hostScanner.Close()
+
+	// Run the scanner.
+	results, err := hostScanner.Scan(ctx)
+
+	// And report what we found, with the name we know the host by. Hosts that
+	// couldn't be scanned still show up with the reason why, unless the scan
+	// was cancelled.
+	for i := range results {
+		results[i].Hostname = hostname
+	}
+
+	printer.Print(results)
+
+	// Hosts that are down weren't scanned, but didn't fail either.
+	if errors.Is(err, scanner.ErrHostDown) {
+		options.Logger.Debug("Host is down", "ip", ip)
+		summary.Skip()
+		return
 	}
 
-	sshScanner.PCAPHandle = pcapHandle
+	if err != nil {
+		options.Logger.Debug("Unable to scan", "ip", ip, "err", err)
+		summary.Fail()
+		return
+	}
 
-	return sshScanner, nil
+	summary.Add(results)
 }
 
-// expand : A helper function to manage an IP group.
-func expand(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
+// shutdownGrace is how long the scans still running get to finish when the
+// scan is interrupted, before we tell we're waiting on them.
+const shutdownGrace = time.Second * 2
 
-		if ip[j] > 0 {
-			break
+// dryRun : Writes out every host along with the interface and source address
+// it would be scanned from, without sending anything.
+func dryRun(writer io.Writer, each func(yield func(ip net.IP) bool), options scanner.Options) error {
+	router := options.Router
+
+	if router == nil {
+		var err error
+
+		if router, err = routing.New(); err != nil {
+			return err
 		}
 	}
+
+	each(func(ip net.IP) bool {
+		var iface *net.Interface
+		var src net.IP
+		var err error
+
+		if options.Interface != "" {
+			iface, _, src, err = scanner.ForcedRoute(router, options.Interface, ip)
+		} else {
+			iface, _, src, err = router.Route(ip)
+		}
+
+		// Unroutable hosts are still listed, they'd just fail to scan.
+		if err != nil {
+			fmt.Fprintf(writer, "%s,,\n", ip)
+			return true
+		}
+
+		fmt.Fprintf(writer, "%s,%s,%s\n", ip, iface.Name, src)
+		return true
+	})
+
+	return nil
 }
 
-// remove : Removes an item form an array.
-func remove(slice []string, sshScanner int) []string {
-	return append(slice[:sshScanner], slice[sshScanner + 1:]...)
+// feed : Sends the hosts down a channel, which gets closed once they've all
+// been sent or ctx is done.
+func feed(ctx context.Context, each func(yield func(ip net.IP) bool)) <-chan net.IP {
+	hosts := make(chan net.IP)
+
+	go func() {
+		defer close(hosts)
+
+		each(func(ip net.IP) bool {
+			select {
+			case hosts <- ip:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return hosts
 }
 
+// The exit codes of runs that don't go as they should: exitFound is what
+// -fail-on sets going by the results, and exitError is for runs that couldn't
+// be done at all, such as with bad flags, whatever -fail-on says.
+const (
+	exitFound = 1
+	exitError = 2
+)
+
 func main() {
-	// Parse all command line arguments, which should just be IPs.
-	flag.Parse()
+	settings, err := parseFlags(flag.CommandLine, os.Args[1:])
+
+	if err != nil {
+		os.Exit(fail(err))
+	}
+
+	// The exit code is only set once the run has been cleaned up, since
+	// exiting skips the deferred calls.
+	if code := run(settings); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// fail : Tells about an error that keeps the run from being done, returning
+// the exit code for it.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	return exitError
+}
+
+// expandTargets : Parses the targets from the command line, and from the target
+// file if there is one, into an Expander handing out their hosts.
+func expandTargets(settings *Settings) (*Expander, error) {
+	args := settings.Targets
+
+	if settings.TargetFile != "" {
+		lines, err := readTargetFile(settings.TargetFile)
 
-	// Instanciate a new router.
-	router, err := routing.New()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, lines...)
+	}
+
+	// Both the order of the hosts and the sample of them are random, the same
+	// every time with a seed.
+	seed := time.Now().UnixNano()
+
+	if settings.Seed != 0 {
+		seed = settings.Seed
+	}
+
+	expander := &Expander{
+		SkipEdges: settings.SkipEdges,
+		Family: settings.Family,
+		Max: settings.MaxTargets,
+		Sample: settings.Sample,
+		Shuffle: settings.Randomize,
+		Rand: rand.New(rand.NewSource(seed)),
+	}
+
+	if err := expander.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Whatever is excluded is left out, even if it's a target too.
+	if err := expander.Exclude(settings.Exclude); err != nil {
+		return nil, err
+	}
+
+	return expander, nil
+}
+
+// run : Scans the targets the way the settings say, returning the exit code.
+func run(settings *Settings) int {
+	options := settings.Options
+	logger := options.Logger
+
+	if options.SourceIP != nil {
+		logger.Warn("Sending from another source IP, replies only come back if it routes to this host", "source", options.SourceIP)
+	}
+
+	// Cancel everything that's still going on when the user hits Ctrl-C. The
+	// scanners bail out and close their PCAP handles on their own.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Connect scans leave routing and capturing to the kernel.
+	if !options.Connect {
+		// Instanciate a new router.
+		router, err := routing.New()
+
+		if err != nil {
+			return fail(err)
+		}
+
+		// The scanners share the router and one PCAP handle per interface,
+		// which all get closed once we're done.
+		options.Router = router
+		options.Pool = scanner.NewPool()
+		options.Pool.ReadTimeout = options.ReadTimeout
+		options.Pool.BufferSize = options.BufferSize
+		defer options.Pool.Close()
+	}
+
+	// Go through the targets, only working out how many hosts they come to
+	// for now.
+	expander, err := expandTargets(settings)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return fail(err)
 	}
 
-	// Collect the command line arguments.
-	args := flag.Args()
-	i := 0
+	// The hosts get handed out one at a time as they're scanned, rather than
+	// all being expanded first.
+	each := expander.Walk
 
-	// Go through the IP nets and expand everything.
-	for _, arg := range args {
-		if strings.ContainsAny(arg, "/") {
-			ip, ipnet, err := net.ParseCIDR(arg)
+	// Leave out the hosts a previous scan already got through.
+	if settings.Resume != "" {
+		scanned, err := readScanned(settings.Resume)
 
-			if err != nil {
-				fmt.Println(err)
-			}
+		// The output being resumed into isn't there on the first run.
+		if errors.Is(err, fs.ErrNotExist) && settings.Resume == settings.Output {
+			scanned, err = map[string]struct{}{}, nil
+		}
 
-			for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); expand(ip) {
-				args = append(args, ip.String())
-			}
+		if err != nil {
+			return fail(err)
+		}
+
+		each = func(yield func(ip net.IP) bool) {
+			expander.Walk(func(ip net.IP) bool {
+				return alreadyScanned(scanned, ip, options.Ports) || yield(ip)
+			})
+		}
+	}
 
-			remove(args, i)
-			i++
+	// Don't go any further than the routing table when only asked what would
+	// be scanned.
+	if settings.DryRun {
+		if err := dryRun(os.Stdout, each, options); err != nil {
+			return fail(err)
 		}
+
+		return 0
 	}
 
-	// A counter that will help us wait until all these jobs are done.
-	wait := len(args)
+	// Results go to stdout, unless a file was given.
+	output := os.Stdout
+	continued := false
 
-	// Now loop through the expanded args and scan everything.
-	for _, arg := range args {
-		var ip net.IP
+	if settings.Output != "" {
+		var err error
 
-		if ip = net.ParseIP(arg); ip == nil {
-			fmt.Printf("Invalid IP entered: %q\n", arg)
-			continue
-		} else if ip = ip.To4(); ip == nil {
-			fmt.Printf("Non-IPv4 target: %q\n", arg)
-			continue
+		// Resuming into the same file adds to it instead of starting over,
+		// however its path was spelled.
+		if settings.Resume != "" && sameFile(settings.Output, settings.Resume) {
+			output, continued, err = openAppend(settings.Output, settings.Format)
+		} else {
+			output, err = os.Create(settings.Output)
 		}
 
-		go func() bool {
-			// Create a new SSH scanner.
-			sshScanner, err := create(ip, router)
+		if err != nil {
+			return fail(err)
+		}
 
-			if err != nil {
-				fmt.Printf("Unable to create scanner for %v: %v\n", ip, err)
-				wait--
-				return false
+		defer output.Close()
+	}
+
+	printer, err := NewPrinter(output, settings.Format, continued)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	printer.OnlyOpen = settings.OnlyOpen
+	printer.Pretty = settings.Pretty
+	printer.Reason = settings.Reason
+
+	// Whatever happens, finish off the output.
+	defer printer.Close()
+
+	// The results can go into a database on top of that.
+	if settings.Database != "" {
+		database, err := OpenDatabase(settings.Database)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		printer.Database = database
+
+		// Bailing out before the scan is over still leaves the database
+		// closed properly, with whatever went into it.
+		defer func() {
+			if printer.Database != nil {
+				printer.Database.Close()
 			}
+		}()
+	}
 
-			// Run the scanner.
-			if err := sshScanner.ScanAddress(); err != nil {
-				wait--
-				return false
+	// Serve the metrics for as long as the scan runs.
+	if settings.Metrics != "" {
+		listener, err := net.Listen("tcp", settings.Metrics)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		defer listener.Close()
+
+		options.Metrics = scanner.NewMetrics()
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", options.Metrics)
+
+		go http.Serve(listener, mux)
+	}
+
+	// Check that we can capture packets at all before starting, so a missing
+	// sudo shows up once instead of once for every target. Without it, SYN
+	// scans can still be done by connecting to the ports.
+	var first net.IP
+
+	each(func(ip net.IP) bool {
+		first = ip
+		return false
+	})
+
+	if first != nil && !options.Connect {
+		name := options.Interface
+
+		if name == "" {
+			if iface, _, _, err := options.Router.Route(first); err == nil {
+				name = iface.Name
 			}
+		}
 
-			// Stop the scanner.
-			sshScanner.Close()
+		if name != "" {
+			if err := options.Pool.Prepare(name); errors.Is(err, scanner.ErrPermission) {
+				if options.ScanType != scanner.SYNScan || options.SourceIP != nil {
+					return fail(err)
+				}
 
-			wait--
+				logger.Warn("Falling back to connect scans", "err", err)
+				options.Connect = true
+			}
+		}
+	}
+
+	// Keep the totals for the summary.
+	summary := NewSummary()
+
+	// Hostnames that couldn't be resolved don't stop the others from being
+	// scanned, but they do count as failed.
+	for host, err := range expander.Failures {
+		logger.Warn("Unable to scan", "host", host, "err", err)
+		summary.Fail()
+	}
 
+	// Every so often, tell how far along we are and how long the rest should
+	// take, which takes counting the hosts first.
+	stopProgress := make(chan struct{})
+
+	if !settings.Quiet {
+		total := len(expander.Failures)
+
+		each(func(ip net.IP) bool {
+			total++
 			return true
+		})
+
+		go reportProgress(os.Stderr, summary, total, stopProgress)
+	}
+
+	// Resolve where packets to every host go before scanning any of them, so
+	// that the scans don't each wait on ARP in turn, and keep the addresses
+	// around until the last of them is scanned.
+	if options.MACCache != nil {
+		options.MACCache.Hold()
+	}
+
+	failures := scanner.ResolveAll(ctx, feed(ctx, each), options, settings.Workers)
+
+	// Feed the targets through a channel so that only a fixed number of
+	// scans are running at any given time.
+	targets := make(chan net.IP, settings.Workers)
+
+	// A wait group that will help us wait until all the workers are done.
+	var wg sync.WaitGroup
+
+	for w := 0; w < settings.Workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// Every worker shares the same router and PCAP handles.
+			worker(ctx, targets, options, expander.Names, printer, summary)
 		}()
 	}
 
-	// A bit hacky, but it works for now.
-	for {
-		if wait == 0 {
-			break
+	// Now go through the hosts and queue every one of them.
+	each(func(ip net.IP) bool {
+		// Hosts that didn't answer ARP won't answer it now either.
+		if err, ok := failures[ip.String()]; ok {
+			logger.Debug("Unable to scan", "ip", ip, "err", err)
+			summary.Fail()
+
+			results := scanner.FailedResults(ip, options.Ports, err)
+
+			for i := range results {
+				results[i].Hostname = expander.Names[ip.String()]
+			}
+
+			printer.Print(results)
+			return true
+		}
+
+		// Stop queueing targets once the scan has been cancelled.
+		select {
+		case targets <- ip:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+
+	// No more targets, so let the workers drain the channel and wait for them.
+	close(targets)
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// Give the scans that were cut short a moment to wind down, such as
+		// banner grabs still reading, and let a second Ctrl-C kill us
+		// outright in the meantime.
+		stop()
+
+		select {
+		case <-done:
+		case <-time.After(shutdownGrace):
+			// Their results would be dropped otherwise, once the output is
+			// closed. It doesn't take much longer, since banner grabs are
+			// bounded by -banner-timeout.
+			logger.Warn("Waiting for the scans still running, interrupt again to quit", "after", shutdownGrace)
+			<-done
 		}
 	}
-}
 
+	close(stopProgress)
+
+	if options.MACCache != nil {
+		options.MACCache.Release()
+	}
+
+	// Whatever was scanned before then is written out in full, and the PCAP
+	// handles get closed on the way out.
+	printer.Close()
+
+	exitCode := 0
+
+	if printer.Database != nil {
+		err := printer.Database.Close()
+		printer.Database = nil
+
+		if err != nil {
+			exitCode = fail(err)
+		}
+	}
+
+	if !settings.Quiet {
+		summary.Print(os.Stderr)
+	}
+
+	// Dial-failed ports count as open here too. Results that didn't all make
+	// it into the database don't get a verdict.
+	found := summary.OpenPorts() > 0
+
+	if exitCode == 0 && ((settings.FailOn == "open" && found) || (settings.FailOn == "none" && !found)) {
+		exitCode = exitFound
+	}
+
+	return exitCode
+}
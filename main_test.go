@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// TestMain runs main instead of the tests when the test binary is run by
+// runMain, with the arguments after its own.
+func TestMain(m *testing.M) {
+	if os.Getenv("SHELLSCAN_MAIN") == "1" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runMain : Runs shellscan with the given arguments, returning its exit code.
+func runMain(t *testing.T, args ...string) int {
+	_, _, code := runMainOutput(t, args...)
+	return code
+}
+
+// runMainOutput : Runs shellscan with the given arguments, returning what it
+// wrote to stdout and to stderr along with its exit code.
+func runMainOutput(t *testing.T, args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+
+	command := exec.Command(os.Args[0], append([]string{"--"}, args...)...)
+	command.Env = append(os.Environ(), "SHELLSCAN_MAIN=1")
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	err := command.Run()
+
+	var exitErr *exec.ExitError
+
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stdout.String(), stderr.String(), 0
+}
+
+// serveSSH : Serves a connection like an SSH server does, up to sending its
+// identification string.
+func serveSSH(conn net.Conn) {
+	conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1\r\n"))
+}
+
+func TestExitCode(t *testing.T) {
+	// An SSH server to find, and a port nothing listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1\r\n"))
+			conn.Close()
+		}
+	}()
+
+	open := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	scan := []string{"-q", "-connect", "-o", filepath.Join(t.TempDir(), "results")}
+
+	for _, c := range []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"open", append(scan, "-ports", open, "127.0.0.1"), 0},
+		{"fail on open", append(scan, "-fail-on", "open", "-ports", open, "127.0.0.1"), exitFound},
+		{"fail on open, none found", append(scan, "-fail-on", "open", "-ports", "1", "127.0.0.1"), 0},
+		{"fail on none", append(scan, "-fail-on", "none", "-ports", "1", "127.0.0.1"), exitFound},
+		{"bad flag value", append(scan, "-fail-on", "none", "-workers", "0", "127.0.0.1"), exitError},
+		{"unknown flag", append(scan, "-fail-on", "none", "-no-such-flag", "127.0.0.1"), exitError},
+		{"missing target list", append(scan, "-fail-on", "none", "-iL", filepath.Join(t.TempDir(), "missing")), exitError},
+		{"missing interface", append(scan, "-fail-on", "none", "-i", "no-such-iface0", "127.0.0.1"), exitError},
+	} {
+		if got := runMain(t, c.args...); got != c.want {
+			t.Errorf("%s: got exit code %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+// listenAll : Starts a server on the same port of every one of the given
+// addresses, which serves every connection with the given function and closes
+// it, and returns the port.
+func listenAll(t *testing.T, ips []string, serve func(conn net.Conn)) string {
+	port := "0"
+
+	for _, ip := range ips {
+		listener, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			listener.Close()
+		})
+
+		port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer conn.Close()
+					serve(conn)
+				}()
+			}
+		}()
+	}
+
+	return port
+}
+
+// TestWorkers checks that no more hosts get scanned at once than there are
+// workers.
+func TestWorkers(t *testing.T) {
+	ips := []string{}
+
+	for i := 1; i <= 8; i++ {
+		ips = append(ips, fmt.Sprintf("127.0.0.%d", i))
+	}
+
+	var active, most atomic.Int32
+
+	port := listenAll(t, ips, func(conn net.Conn) {
+		n := active.Add(1)
+
+		for {
+			if m := most.Load(); n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		// The connection counts as done before the banner goes out, which is
+		// what lets the worker move on to its next host.
+		time.Sleep(time.Millisecond * 100)
+		active.Add(-1)
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1\r\n"))
+	})
+
+	args := []string{"-q", "-connect", "-workers", "2", "-ports", port, "-o", filepath.Join(t.TempDir(), "results"), "127.0.0.1-8"}
+
+	if code := runMain(t, args...); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	if most.Load() != 2 {
+		t.Errorf("got %d hosts scanned at once, want 2", most.Load())
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	stdout, _, code := runMainOutput(t, "-q", "-connect", "-format", "csv", "-ports", open + ",1", "-o", path, "127.0.0.1")
+
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	// Every result goes into the file, and none of them to stdout.
+	if stdout != "" {
+		t.Errorf("got %q on stdout, want nothing", stdout)
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ip,port,state,banner,hostname,error\n" +
+		"127.0.0.1,1,closed,,,\n" +
+		"127.0.0.1," + open + ",open,SSH-2.0-OpenSSH_9.6p1,,\n"
+
+	if string(data) != want {
+		t.Errorf("got file\n%s\nwant\n%s", data, want)
+	}
+}
+
+// TestStdoutOnlyResults checks that whatever else gets written out, with -v or
+// without, stdout only has the results on it.
+func TestStdoutOnlyResults(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+	want := "127.0.0.1," + open + ",SSH-2.0-OpenSSH_9.6p1\n"
+
+	for _, verbose := range []bool{false, true} {
+		args := []string{"-connect", "-ports", open + ",1", "127.0.0.1"}
+
+		if verbose {
+			args = append([]string{"-v"}, args...)
+		}
+
+		stdout, stderr, code := runMainOutput(t, args...)
+
+		if code != 0 {
+			t.Fatalf("verbose %v: got exit code %d", verbose, code)
+		}
+
+		if stdout != want {
+			t.Errorf("verbose %v: got stdout %q, want %q", verbose, stdout, want)
+		}
+
+		// The summary still goes to stderr, along with the debugging output
+		// when asked for it.
+		if !strings.Contains(stderr, "Scanned 1 hosts") {
+			t.Errorf("verbose %v: got no summary on stderr: %q", verbose, stderr)
+		}
+
+		if debug := strings.Contains(stderr, "level=DEBUG"); debug != verbose {
+			t.Errorf("verbose %v: got stderr %q", verbose, stderr)
+		}
+	}
+}
+
+// TestPermissionError checks what happens without the privileges to capture
+// packets, which takes not running as root.
+func TestPermissionError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can capture packets")
+	}
+
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+
+	// Only SYN scans can be done by connecting instead.
+	stdout, stderr, code := runMainOutput(t, "-q", "-scan-type", "fin", "-ports", open, "127.0.0.1")
+
+	if code != exitError || stdout != "" || !strings.Contains(stderr, "Error: " + scanner.ErrPermission.Error()) {
+		t.Errorf("fin scan: got exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runMainOutput(t, "-q", "-ports", open, "127.0.0.1")
+
+	if code != 0 || !strings.Contains(stderr, "Falling back to connect scans") || !strings.Contains(stdout, "SSH-2.0-OpenSSH_9.6p1") {
+		t.Errorf("syn scan: got exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+}
+
+func TestTargetFile(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4"}, serveSSH)
+	path := filepath.Join(t.TempDir(), "targets")
+
+	if err := os.WriteFile(path, []byte("# The lab\n127.0.0.1\n\n  127.0.0.2-3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The targets in the file are scanned along with the ones on the command
+	// line.
+	stdout, _, code := runMainOutput(t, "-q", "-connect", "-ports", open, "-iL", path, "127.0.0.4")
+
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	sort.Strings(lines)
+
+	want := []string{}
+
+	for i := 1; i <= 4; i++ {
+		want = append(want, fmt.Sprintf("127.0.0.%d,%s,SSH-2.0-OpenSSH_9.6p1", i, open))
+	}
+
+	if !slices.Equal(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestSummaryOutput(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1", "127.0.0.2"}, serveSSH)
+
+	_, stderr, code := runMainOutput(t, "-connect", "-ports", open + ",1", "127.0.0.1-2")
+
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	if !strings.Contains(stderr, "Scanned 2 hosts (0 failed) in ") || !strings.HasSuffix(stderr, ": 2 open, 2 closed, 0 filtered ports\n") {
+		t.Errorf("got stderr %q", stderr)
+	}
+}
+
+func TestOnlyOpen(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "127.0.0.1,1,closed,,,\n127.0.0.1," + open + ",open,SSH-2.0-OpenSSH_9.6p1,,\n"},
+		{[]string{"-open"}, "127.0.0.1," + open + ",open,SSH-2.0-OpenSSH_9.6p1,,\n"},
+	} {
+		args := append([]string{"-q", "-connect", "-format", "csv", "-ports", open + ",1"}, c.args...)
+		stdout, _, code := runMainOutput(t, append(args, "127.0.0.1")...)
+
+		if code != 0 {
+			t.Fatalf("%v: got exit code %d", c.args, code)
+		}
+
+		if want := "ip,port,state,banner,hostname,error\n" + c.want; stdout != want {
+			t.Errorf("%v: got\n%s\nwant\n%s", c.args, stdout, want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"127.0.0.0/30"}, "127.0.0.1,lo,127.0.0.1\n127.0.0.2,lo,127.0.0.1\n"},
+		{[]string{"-skip-network-broadcast=false", "127.0.0.0/30"}, "127.0.0.0,lo,127.0.0.1\n127.0.0.1,lo,127.0.0.1\n127.0.0.2,lo,127.0.0.1\n127.0.0.3,lo,127.0.0.1\n"},
+		{[]string{"-exclude", "127.0.0.2", "127.0.0.0/29"}, "127.0.0.1,lo,127.0.0.1\n127.0.0.3,lo,127.0.0.1\n127.0.0.4,lo,127.0.0.1\n127.0.0.5,lo,127.0.0.1\n127.0.0.6,lo,127.0.0.1\n"},
+	} {
+		// Nothing gets scanned, so nothing else gets written out.
+		stdout, stderr, code := runMainOutput(t, append([]string{"-dry-run", "-i", "lo"}, c.args...)...)
+
+		if code != 0 || stderr != "" {
+			t.Errorf("%v: got exit code %d, stderr %q", c.args, code, stderr)
+		}
+
+		if stdout != c.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", c.args, stdout, c.want)
+		}
+	}
+}
+
+// TestDryRunOutput checks that a dry run leaves the output file alone, since
+// no results go into it.
+func TestDryRunOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	if err := os.WriteFile(path, []byte("previous results\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, stderr, code := runMainOutput(t, "-dry-run", "-i", "lo", "-format", "csv", "-o", path, "127.0.0.1"); code != 0 {
+		t.Fatalf("got exit code %d, stderr %q", code, stderr)
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "previous results\n" {
+		t.Errorf("got output file %q (%v)", data, err)
+	}
+}
+
+// TestResumeSameFile checks that resuming into the output, under another path
+// to it, adds to it rather than starting it over.
+func TestResumeSameFile(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1", "127.0.0.2"}, serveSSH)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.csv")
+
+	if code := runMain(t, "-q", "-connect", "-format", "csv", "-ports", open, "-o", path, "127.0.0.1"); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	if code := runMain(t, "-q", "-connect", "-format", "csv", "-ports", open, "-o", path, "-resume", dir + "/./results.csv", "127.0.0.1-2"); code != 0 {
+		t.Fatalf("got exit code %d on resuming", code)
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ip,port,state,banner,hostname,error\n127.0.0.1," + open + ",open,SSH-2.0-OpenSSH_9.6p1,,\n127.0.0.2," + open + ",open,SSH-2.0-OpenSSH_9.6p1,,\n"
+
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+}
+
+// TestInterrupt checks that the results of the hosts scanned before Ctrl-C are
+// all written out, in a file that's still a valid JSON array.
+func TestInterrupt(t *testing.T) {
+	ips := []string{}
+
+	for i := 1; i <= 8; i++ {
+		ips = append(ips, fmt.Sprintf("127.0.0.%d", i))
+	}
+
+	port := listenAll(t, ips, func(conn net.Conn) {
+		time.Sleep(time.Millisecond * 200)
+		serveSSH(conn)
+	})
+
+	path := filepath.Join(t.TempDir(), "results.json")
+
+	command := exec.Command(os.Args[0], "--", "-q", "-connect", "-workers", "1", "-format", "json-array", "-ports", port, "-o", path, "127.0.0.1-8")
+	command.Env = append(os.Environ(), "SHELLSCAN_MAIN=1")
+
+	if err := command.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 700)
+
+	if err := command.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := command.Wait(); err != nil {
+		t.Fatalf("got %v after the interrupt", err)
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []map[string]any{}
+
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatalf("%v in %q", err, data)
+	}
+
+	// Some hosts got scanned before then, and their results are complete.
+	if len(results) == 0 || len(results) >= len(ips) {
+		t.Errorf("got results for %d out of %d hosts", len(results), len(ips))
+	}
+
+	for _, result := range results {
+		if result["state"] != "open" || result["banner"] != "SSH-2.0-OpenSSH_9.6p1" {
+			t.Errorf("%v: got %v, banner %v", result["ip"], result["state"], result["banner"])
+		}
+	}
+}
+
+func TestErrorsToStderr(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-workers", "0", "127.0.0.1"}, "Error: -workers must be at least 1\n"},
+		{[]string{"-ports", "22,x", "127.0.0.1"}, "Error: Invalid port: \"x\"\n"},
+		{[]string{"-iL", filepath.Join(t.TempDir(), "missing")}, "no such file or directory\n"},
+		{[]string{"-scan-type", "fin", "127.0.0.1"}, "Error: -connect only works with the syn scan type\n"},
+	} {
+		stdout, stderr, code := runMainOutput(t, append([]string{"-connect"}, c.args...)...)
+
+		if code != exitError {
+			t.Errorf("%v: got exit code %d, want %d", c.args, code, exitError)
+		}
+
+		if stdout != "" {
+			t.Errorf("%v: got %q on stdout, want nothing", c.args, stdout)
+		}
+
+		if !strings.HasPrefix(stderr, "Error: ") || !strings.HasSuffix(stderr, c.want) {
+			t.Errorf("%v: got %q on stderr, want %q", c.args, stderr, c.want)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+	path := writeConfig(t, `{"connect": true, "q": true, "format": "csv", "ports": [1, ` + open + `]}`)
+
+	// The file picks the format and ports, unless the command line does.
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "ip,port,state,banner,hostname,error\n127.0.0.1,1,closed,,,\n127.0.0.1," + open + ",open,SSH-2.0-OpenSSH_9.6p1,,\n"},
+		{[]string{"-format", "plain"}, "127.0.0.1," + open + ",SSH-2.0-OpenSSH_9.6p1\n"},
+		{[]string{"-ports", "1"}, "ip,port,state,banner,hostname,error\n127.0.0.1,1,closed,,,\n"},
+	} {
+		stdout, stderr, code := runMainOutput(t, append(append([]string{"-config", path}, c.args...), "127.0.0.1")...)
+
+		if code != 0 || stderr != "" {
+			t.Fatalf("%v: got exit code %d, stderr %q", c.args, code, stderr)
+		}
+
+		if stdout != c.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", c.args, stdout, c.want)
+		}
+	}
+}
+
+// TestPingConnect checks that connect scans, which can't ping, scan every host
+// with -ping.
+func TestPingConnect(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+
+	stdout, _, code := runMainOutput(t, "-q", "-connect", "-ping", "-ports", open, "127.0.0.1")
+
+	if want := "127.0.0.1," + open + ",SSH-2.0-OpenSSH_9.6p1\n"; code != 0 || stdout != want {
+		t.Errorf("got exit code %d, stdout %q, want %q", code, stdout, want)
+	}
+}
+
+func TestSkipDiscoveryFlags(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1"}, serveSSH)
+
+	// Connect scans leave finding the host to the kernel.
+	stdout, _, code := runMainOutput(t, "-q", "-connect", "-Pn", "-ports", open, "127.0.0.1")
+
+	if want := "127.0.0.1," + open + ",SSH-2.0-OpenSSH_9.6p1\n"; code != 0 || stdout != want {
+		t.Errorf("got exit code %d, stdout %q, want %q", code, stdout, want)
+	}
+
+	// Pinging hosts to skip them doesn't go with scanning them all.
+	if _, stderr, code := runMainOutput(t, "-q", "-connect", "-ping", "-Pn", "127.0.0.1"); code != exitError || stderr != "Error: -ping doesn't work with -Pn\n" {
+		t.Errorf("-ping -Pn: got exit code %d, stderr %q", code, stderr)
+	}
+}
+
+// TestPlainOutput checks the lines of the plain format as they come out on
+// stdout: only open ports, one per line, with the banner last as it was sent.
+func TestPlainOutput(t *testing.T) {
+	open := listenAll(t, []string{"127.0.0.1", "127.0.0.2"}, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13, with a comma\r\n"))
+	})
+
+	for _, c := range []struct {
+		args []string
+		reason string
+	}{
+		{nil, ""},
+		{[]string{"-reason"}, "syn-ack,"},
+	} {
+		args := append([]string{"-q", "-connect", "-ports", "1," + open}, c.args...)
+		stdout, _, code := runMainOutput(t, append(args, "127.0.0.1-2")...)
+
+		if code != 0 {
+			t.Fatalf("%v: got exit code %d", c.args, code)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+		sort.Strings(lines)
+
+		want := []string{
+			"127.0.0.1," + open + "," + c.reason + "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13, with a comma",
+			"127.0.0.2," + open + "," + c.reason + "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13, with a comma",
+		}
+
+		if !slices.Equal(lines, want) {
+			t.Errorf("%v: got %q, want %q", c.args, lines, want)
+		}
+	}
+}
+
+func TestRandomizeSeed(t *testing.T) {
+	order := func(args ...string) []string {
+		stdout, stderr, code := runMainOutput(t, append([]string{"-dry-run", "-i", "lo", "-randomize"}, append(args, "127.0.0.0/26")...)...)
+
+		if code != 0 {
+			t.Fatalf("%v: got exit code %d, stderr %q", args, code, stderr)
+		}
+
+		return strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	}
+
+	first, again, other := order("-seed", "42"), order("-seed", "42"), order("-seed", "43")
+
+	// The same seed gives the same order, another seed another one.
+	if !slices.Equal(first, again) {
+		t.Errorf("seed 42 gave\n%q\nthen\n%q", first, again)
+	}
+
+	if slices.Equal(first, other) {
+		t.Error("seeds 42 and 43 gave the same order")
+	}
+
+	// Every host is still there, just not in order.
+	sorted := slices.Clone(first)
+	sort.Slice(sorted, func(i, j int) bool {
+		return net.ParseIP(strings.Split(sorted[i], ",")[0]).To4()[3] < net.ParseIP(strings.Split(sorted[j], ",")[0]).To4()[3]
+	})
+
+	want := []string{}
+
+	for i := 1; i <= 62; i++ {
+		want = append(want, fmt.Sprintf("127.0.0.%d,lo,127.0.0.1", i))
+	}
+
+	if !slices.Equal(sorted, want) {
+		t.Errorf("got hosts %q, want %q", sorted, want)
+	}
+
+	if slices.Equal(first, want) {
+		t.Error("the hosts weren't shuffled")
+	}
+}
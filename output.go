@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// schemaVersion is the version of the json format, which goes up whenever a
+// field changes meaning or goes away, but not when fields are added.
+const schemaVersion = 1
+
+// record is a result as written out in the json format.
+type record struct {
+	SchemaVersion int `json:"schema_version"`
+	scanner.Result
+
+	// How long the port took to answer, or null if it didn't.
+	RTT *float64 `json:"rtt_ms"`
+}
+
+// newRecord : Wraps a result for the json format.
+func newRecord(result scanner.Result) record {
+	rec := record{SchemaVersion: schemaVersion, Result: result}
+
+	if result.RTT > 0 {
+		ms := float64(result.RTT) / float64(time.Millisecond)
+		rec.RTT = &ms
+	}
+
+	return rec
+}
+
+// Printer writes scan results out in the chosen format.
+type Printer struct {
+	// Where the results end up and how they look.
+	Writer io.Writer
+	Format string
+
+	// Whether to leave out every port that isn't open.
+	OnlyOpen bool
+
+	// Whether to indent the objects of the json formats, for reading them
+	// rather than piping them somewhere.
+	Pretty bool
+
+	// Whether to write out why every port got its state.
+	Reason bool
+
+	// Where the results are written to as well, if it's set.
+	Database *Database
+
+	// Takes care of quoting for the csv format, whose header row goes out
+	// along with the first results, once the columns are known.
+	csv *csv.Writer
+	header bool
+
+	// How many results went into the json-array format so far, and whether
+	// the array has been closed.
+	count int
+	closed bool
+
+	// Results come in from many workers at once, so writes are serialized.
+	mutex sync.Mutex
+}
+
+// NewPrinter : Creates a printer for one of the supported formats, which may
+// carry on from results in the writer already, such as those of a scan being
+// resumed, whose json array is still open or whose csv header is out already.
+func NewPrinter(writer io.Writer, format string, continued bool) (*Printer, error) {
+	switch format {
+	case "plain", "json":
+		return &Printer{Writer: writer, Format: format}, nil
+	case "json-array":
+		// Results in the array already get a comma after them, like ours.
+		if continued {
+			return &Printer{Writer: writer, Format: format, count: 1}, nil
+		}
+
+		// The array only gets closed once we're done.
+		_, err := fmt.Fprint(writer, "[")
+
+		return &Printer{Writer: writer, Format: format}, err
+	case "csv":
+		return &Printer{Writer: writer, Format: format, csv: csv.NewWriter(writer), header: continued}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown output format: %q", format)
+}
+
+// Print : Writes out the results of a single host.
+func (printer *Printer) Print(results []scanner.Result) {
+	printer.mutex.Lock()
+	defer printer.mutex.Unlock()
+
+	// Nothing goes after the end of the array.
+	if printer.closed {
+		return
+	}
+
+	printer.writeHeader()
+
+	kept := make([]scanner.Result, 0, len(results))
+
+	for _, result := range results {
+		if printer.OnlyOpen && result.State != scanner.Open && result.State != scanner.DialFailed {
+			continue
+		}
+
+		kept = append(kept, result)
+
+		// Reasons are only written out when asked for.
+		if !printer.Reason {
+			result.Reason = ""
+		}
+
+		switch printer.Format {
+		case "json":
+			// Newline-delimited JSON, one object per port.
+			data, err := printer.marshal(result, "")
+
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(printer.Writer, "%s\n", data)
+		case "json-array":
+			// A single array of every port, one per line, or indented within
+			// the array when pretty.
+			data, err := printer.marshal(result, "  ")
+
+			if err != nil {
+				continue
+			}
+
+			if printer.count > 0 {
+				fmt.Fprint(printer.Writer, ",")
+			}
+
+			if printer.Pretty {
+				fmt.Fprint(printer.Writer, "\n  ")
+			} else {
+				fmt.Fprint(printer.Writer, "\n")
+			}
+
+			fmt.Fprintf(printer.Writer, "%s", data)
+			printer.count++
+		case "csv":
+			// One row per port, banners get quoted as needed. The columns
+			// that came later go at the end, so the first ones never move.
+			row := []string{result.IP, strconv.Itoa(int(result.Port)), result.State.String(), result.Banner, result.Hostname, result.Error}
+
+			if printer.Reason {
+				row = append(row, result.Reason)
+			}
+
+			printer.csv.Write(row)
+		default:
+			// The plain format only lists the open ports, or the ones that
+			// might be with the scan types open ports don't answer. Lines are
+			// ip,port,banner, or ip,port,reason,banner with the reasons, the
+			// banner last since it may have commas in it. Hostnames are left
+			// to the other formats.
+			if result.State != scanner.Open && result.State != scanner.DialFailed && result.State != scanner.OpenFiltered {
+				continue
+			}
+
+			if printer.Reason {
+				fmt.Fprintf(printer.Writer, "%s,%d,%s,%s\n", result.IP, result.Port, result.Reason, result.Banner)
+			} else {
+				fmt.Fprintf(printer.Writer, "%s,%d,%s\n", result.IP, result.Port, result.Banner)
+			}
+		}
+	}
+
+	if printer.csv != nil {
+		printer.csv.Flush()
+	}
+
+	if printer.Database != nil && len(kept) > 0 {
+		printer.Database.Write(kept)
+	}
+}
+
+// writeHeader : Writes out the header row of the csv format, unless it's
+// already out.
+func (printer *Printer) writeHeader() {
+	if printer.csv == nil || printer.header {
+		return
+	}
+
+	printer.header = true
+
+	header := []string{"ip", "port", "state", "banner", "hostname", "error"}
+
+	if printer.Reason {
+		header = append(header, "reason")
+	}
+
+	printer.csv.Write(header)
+}
+
+// marshal : Turns a result into its json object, indented after the prefix
+// when pretty.
+func (printer *Printer) marshal(result scanner.Result, prefix string) ([]byte, error) {
+	if printer.Pretty {
+		return json.MarshalIndent(newRecord(result), prefix, "  ")
+	}
+
+	return json.Marshal(newRecord(result))
+}
+
+// Flush : Writes out anything the printer is holding on to.
+func (printer *Printer) Flush() {
+	printer.mutex.Lock()
+	defer printer.mutex.Unlock()
+
+	if printer.csv != nil {
+		printer.csv.Flush()
+	}
+}
+
+// Close : Writes out whatever is needed to end the output, such as the end of
+// the array for the json-array format. Results printed after that are dropped.
+func (printer *Printer) Close() {
+	printer.Flush()
+
+	printer.mutex.Lock()
+	defer printer.mutex.Unlock()
+
+	if printer.closed {
+		return
+	}
+
+	printer.closed = true
+
+	// Even a scan with no results gets a header row.
+	if printer.csv != nil && !printer.header {
+		printer.writeHeader()
+		printer.csv.Flush()
+	}
+
+	if printer.Format == "json-array" {
+		fmt.Fprint(printer.Writer, "\n]\n")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+func TestPrintJSON(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	printer, err := NewPrinter(buffer, "json", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printer.Print([]scanner.Result{
+		{IP: "10.0.0.1", Port: 22, State: scanner.Open, Banner: "SSH-2.0-dropbear_2012.55", Time: time.Now(), RTT: time.Microsecond * 1420},
+		{IP: "10.0.0.1", Port: 2222, State: scanner.Filtered, Time: time.Now()},
+	})
+
+	printer.Close()
+
+	// Every object has the documented fields, with the documented types.
+	lines := bufio.NewScanner(buffer)
+	count := 0
+
+	for lines.Scan() {
+		fields := map[string]any{}
+
+		if err := json.Unmarshal(lines.Bytes(), &fields); err != nil {
+			t.Fatalf("%s: %v", lines.Text(), err)
+		}
+
+		count++
+
+		if fields["schema_version"] != float64(schemaVersion) {
+			t.Errorf("%s: got schema_version %v", lines.Text(), fields["schema_version"])
+		}
+
+		for _, name := range []string{"timestamp", "ip", "state"} {
+			if _, ok := fields[name].(string); !ok {
+				t.Errorf("%s: %s isn't a string", lines.Text(), name)
+			}
+		}
+
+		if _, err := time.Parse(time.RFC3339Nano, fields["timestamp"].(string)); err != nil {
+			t.Errorf("%s: %v", lines.Text(), err)
+		}
+
+		if _, ok := fields["port"].(float64); !ok {
+			t.Errorf("%s: port isn't a number", lines.Text())
+		}
+
+		rtt, timed := fields["rtt_ms"].(float64)
+
+		switch fields["state"] {
+		case "open":
+			if !timed || rtt <= 0 {
+				t.Errorf("%s: got rtt_ms %v for an open port", lines.Text(), fields["rtt_ms"])
+			}
+
+			if fields["banner"] != "SSH-2.0-dropbear_2012.55" {
+				t.Errorf("%s: got banner %v", lines.Text(), fields["banner"])
+			}
+		default:
+			if value, ok := fields["rtt_ms"]; !ok || value != nil {
+				t.Errorf("%s: got rtt_ms %v for a port that didn't answer", lines.Text(), value)
+			}
+		}
+	}
+
+	if count != 2 {
+		t.Errorf("got %d objects, want 2", count)
+	}
+}
+
+func TestPrintCSV(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	printer, err := NewPrinter(buffer, "csv", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printer.Print([]scanner.Result{
+		{IP: "10.0.0.1", Port: 22, State: scanner.Open, Banner: "SSH-2.0-OpenSSH_7.4, with \"quotes\""},
+		{IP: "10.0.0.1", Port: 2222, State: scanner.Closed},
+	})
+
+	printer.Close()
+
+	rows, err := csv.NewReader(buffer).ReadAll()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"ip", "port", "state", "banner", "hostname", "error"},
+		{"10.0.0.1", "22", "open", "SSH-2.0-OpenSSH_7.4, with \"quotes\"", "", ""},
+		{"10.0.0.1", "2222", "closed", "", "", ""},
+	}
+
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %q, want %q", rows, want)
+	}
+}
+
+func TestPrintHostname(t *testing.T) {
+	results := []scanner.Result{
+		{IP: "93.184.215.14", Port: 22, Hostname: "git.example.com", State: scanner.Open, Reason: "syn-ack", Banner: "SSH-2.0-OpenSSH_9.6"},
+		{IP: "10.0.0.1", Port: 22, State: scanner.Open, Reason: "syn-ack", Banner: "SSH-2.0-dropbear_2012.55"},
+	}
+
+	for _, c := range []struct {
+		format string
+		reason bool
+		want string
+	}{
+		{"plain", false, "93.184.215.14,22,SSH-2.0-OpenSSH_9.6\n10.0.0.1,22,SSH-2.0-dropbear_2012.55\n"},
+		{"plain", true, "93.184.215.14,22,syn-ack,SSH-2.0-OpenSSH_9.6\n10.0.0.1,22,syn-ack,SSH-2.0-dropbear_2012.55\n"},
+		{"csv", false, "ip,port,state,banner,hostname,error\n93.184.215.14,22,open,SSH-2.0-OpenSSH_9.6,git.example.com,\n10.0.0.1,22,open,SSH-2.0-dropbear_2012.55,,\n"},
+		{"csv", true, "ip,port,state,banner,hostname,error,reason\n93.184.215.14,22,open,SSH-2.0-OpenSSH_9.6,git.example.com,,syn-ack\n10.0.0.1,22,open,SSH-2.0-dropbear_2012.55,,,syn-ack\n"},
+	} {
+		buffer := &bytes.Buffer{}
+		printer, err := NewPrinter(buffer, c.format, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		printer.Reason = c.reason
+		printer.Print(results)
+		printer.Close()
+
+		if buffer.String() != c.want {
+			t.Errorf("%s (reason %v): got %q, want %q", c.format, c.reason, buffer.String(), c.want)
+		}
+	}
+}
+
+func TestPrintOnlyOpen(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	printer, err := NewPrinter(buffer, "csv", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printer.OnlyOpen = true
+
+	// Ports that answered but couldn't be connected to count as open, ports
+	// that may or may not be don't.
+	printer.Print([]scanner.Result{
+		{IP: "10.0.0.1", Port: 22, State: scanner.Open, Banner: "SSH-2.0-OpenSSH_9.6"},
+		{IP: "10.0.0.1", Port: 23, State: scanner.Closed},
+		{IP: "10.0.0.1", Port: 2222, State: scanner.DialFailed},
+		{IP: "10.0.0.1", Port: 8022, State: scanner.Filtered},
+		{IP: "10.0.0.1", Port: 9022, State: scanner.OpenFiltered},
+	})
+
+	printer.Close()
+
+	want := "ip,port,state,banner,hostname,error\n10.0.0.1,22,open,SSH-2.0-OpenSSH_9.6,,\n10.0.0.1,2222,dial-failed,,,\n"
+
+	if buffer.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buffer, want)
+	}
+}
+
+func TestPrintPretty(t *testing.T) {
+	results := []scanner.Result{
+		{IP: "10.0.0.1", Port: 22, State: scanner.Open, Banner: "SSH-2.0-dropbear_2012.55", Time: time.Unix(1714564800, 0).UTC(), RTT: time.Microsecond * 1420},
+		{IP: "10.0.0.1", Port: 2222, State: scanner.Closed, Time: time.Unix(1714564800, 0).UTC()},
+	}
+
+	// Prints the results in the format, returning the output and the objects
+	// it decodes to.
+	output := func(format string, pretty bool) (string, []map[string]any) {
+		buffer := &bytes.Buffer{}
+		printer, err := NewPrinter(buffer, format, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		printer.Pretty = pretty
+		printer.Print(results)
+		printer.Close()
+
+		objects := []map[string]any{}
+		decoder := json.NewDecoder(bytes.NewReader(buffer.Bytes()))
+
+		if format == "json-array" {
+			if err := decoder.Decode(&objects); err != nil {
+				t.Fatalf("%s (pretty %v): %v\n%s", format, pretty, err, buffer)
+			}
+
+			return buffer.String(), objects
+		}
+
+		for decoder.More() {
+			object := map[string]any{}
+
+			if err := decoder.Decode(&object); err != nil {
+				t.Fatalf("%s (pretty %v): %v\n%s", format, pretty, err, buffer)
+			}
+
+			objects = append(objects, object)
+		}
+
+		return buffer.String(), objects
+	}
+
+	for _, format := range []string{"json", "json-array"} {
+		compact, compactObjects := output(format, false)
+		pretty, prettyObjects := output(format, true)
+
+		// Both say the same thing...
+		if len(compactObjects) != len(results) || !reflect.DeepEqual(compactObjects, prettyObjects) {
+			t.Errorf("%s: compact gave %v, pretty gave %v", format, compactObjects, prettyObjects)
+		}
+
+		// ...one object per line when compact...
+		lines := strings.Split(strings.TrimSuffix(compact, "\n"), "\n")
+
+		if format == "json-array" {
+			if len(lines) != len(results) + 2 || lines[0] != "[" || lines[len(lines) - 1] != "]" {
+				t.Errorf("%s: got compact\n%s", format, compact)
+			}
+
+			lines = lines[1:len(lines) - 1]
+		}
+
+		for i, line := range lines {
+			// Only the objects of the array are followed by a comma, but for
+			// the last.
+			if format == "json-array" && i < len(lines) - 1 {
+				line = strings.TrimSuffix(line, ",")
+			}
+
+			object := map[string]any{}
+
+			if err := json.Unmarshal([]byte(line), &object); err != nil {
+				t.Errorf("%s: got compact line %q: %v", format, line, err)
+			}
+		}
+
+		// ...and indented when pretty, within the array too.
+		indent := "{\n  \"schema_version\": 1,"
+
+		if format == "json-array" {
+			indent = "[\n  {\n    \"schema_version\": 1,"
+
+			if !strings.Contains(pretty, "},\n  {\n    ") || !strings.HasSuffix(pretty, "\n  }\n]\n") {
+				t.Errorf("%s: got pretty\n%s", format, pretty)
+			}
+		}
+
+		if !strings.HasPrefix(pretty, indent) {
+			t.Errorf("%s: got pretty\n%s\nwant it to start with\n%s", format, pretty, indent)
+		}
+	}
+}
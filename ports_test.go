@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	for _, c := range []struct {
+		list string
+		want []uint16
+		err bool
+	}{
+		{"22", []uint16{22}, false},
+		{"8080, 22,443", []uint16{22, 443, 8080}, false},
+		{"1-5,3306,8080-8082", []uint16{1, 2, 3, 4, 5, 3306, 8080, 8081, 8082}, false},
+		{"20-25,22,24-26", []uint16{20, 21, 22, 23, 24, 25, 26}, false},
+		{"65535", []uint16{65535}, false},
+		{"22-22", []uint16{22}, false},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"0-10", nil, true},
+		{"100-1", nil, true},
+		{"22,", nil, true},
+		{"ssh", nil, true},
+		{"22-", nil, true},
+		{"1-2-3", nil, true},
+	} {
+		ports, err := parsePorts(c.list)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", c.list, ports)
+			}
+
+			continue
+		}
+
+		if err != nil || !slices.Equal(ports, c.want) {
+			t.Errorf("%q: got %v, %v, want %v", c.list, ports, err, c.want)
+		}
+	}
+}
+
+func TestTopPortList(t *testing.T) {
+	ports, err := parsePorts(topPortList(10))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint16{21, 22, 23, 25, 80, 110, 139, 443, 445, 3389}
+
+	if !slices.Equal(ports, want) {
+		t.Errorf("got %v, want %v", ports, want)
+	}
+
+	// There are no more than the built-in ones, each of them once.
+	if ports, _ := parsePorts(topPortList(1000)); len(ports) != len(topPorts) {
+		t.Errorf("got %d ports, want all %d", len(ports), len(topPorts))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is how often the progress of a scan is reported.
+const progressInterval = time.Second * 5
+
+// reportProgress : Writes out how many of the total hosts are done every
+// progressInterval, along with how fast they're going and how long the rest
+// should take at that pace, until stop is closed.
+func reportProgress(writer io.Writer, summary *Summary, total int, stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		done := summary.Done()
+
+		// Nothing to go by until the first hosts are done.
+		if done == 0 {
+			fmt.Fprintf(writer, "Scanned 0/%d hosts\n", total)
+			continue
+		}
+
+		rate, remaining := estimate(done, total, time.Since(summary.Start))
+		fmt.Fprintf(writer, "Scanned %d/%d hosts, %.1f hosts/s, about %v left\n", done, total, rate, remaining.Round(time.Second))
+	}
+}
+
+// estimate : Works out how many hosts are scanned per second, going by the ones
+// done so far, and how long the rest of them should take at that rate.
+func estimate(done int, total int, elapsed time.Duration) (float64, time.Duration) {
+	if done <= 0 || elapsed <= 0 {
+		return 0, 0
+	}
+
+	rate := float64(done) / elapsed.Seconds()
+	left := total - done
+
+	if left < 0 {
+		left = 0
+	}
+
+	return rate, time.Duration(float64(left) / rate * float64(time.Second))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	for _, c := range []struct {
+		done int
+		total int
+		elapsed time.Duration
+		rate float64
+		left time.Duration
+	}{
+		{10, 100, time.Second * 5, 2, time.Second * 45},
+		{50, 100, time.Second * 10, 5, time.Second * 10},
+		{100, 100, time.Minute, 100.0 / 60, 0},
+		{3, 1000, time.Millisecond * 1500, 2, time.Second * 498 + time.Millisecond * 500},
+
+		// Nothing is left once the count is reached, whatever it was.
+		{120, 100, time.Second * 10, 12, 0},
+
+		// Nothing to go by yet.
+		{0, 100, time.Second * 5, 0, 0},
+		{10, 100, 0, 0, 0},
+	} {
+		rate, left := estimate(c.done, c.total, c.elapsed)
+
+		if rate != c.rate || left != c.left {
+			t.Errorf("%d/%d in %v: got %.2f hosts/s, %v left, want %.2f, %v", c.done, c.total, c.elapsed, rate, left, c.rate, c.left)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// readScanned : Reads the ports a previous scan wrote out, keyed on ip:port,
+// from output in any of the formats. A last result that only got written in
+// part is ignored, along with anything else that doesn't parse, and so are the
+// ports of hosts that couldn't be scanned, such as ones that didn't answer ARP,
+// so that they get another try.
+func readScanned(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanned := make(map[string]struct{})
+	reader := bufio.NewReader(file)
+
+	// The json formats are read as a stream of objects, whether they're one
+	// per line or indented.
+	if first, err := peekByte(reader); err == nil && (first == '{' || first == '[') {
+		readScannedJSON(reader, scanned)
+		return scanned, nil
+	}
+
+	lines := bufio.NewScanner(reader)
+
+	// Banners can make for long lines.
+	lines.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+
+	// Where the error is in the rows of the csv format, as said by its
+	// header. The plain format has none.
+	errorColumn := -1
+
+	for lines.Scan() {
+		// The csv and plain formats start off with the IP and port, and the
+		// csv header doesn't have a port.
+		fields, err := csv.NewReader(strings.NewReader(lines.Text())).Read()
+
+		if err != nil || len(fields) < 2 {
+			continue
+		}
+
+		if fields[0] == "ip" {
+			errorColumn = slices.Index(fields, "error")
+			continue
+		}
+
+		if net.ParseIP(fields[0]) == nil {
+			continue
+		}
+
+		if errorColumn >= 0 && errorColumn < len(fields) && fields[errorColumn] != "" {
+			continue
+		}
+
+		if port, err := strconv.ParseUint(fields[1], 10, 16); err == nil {
+			scanned[net.JoinHostPort(fields[0], strconv.Itoa(int(port)))] = struct{}{}
+		}
+	}
+
+	return scanned, lines.Err()
+}
+
+// peekByte : Returns the first byte that isn't whitespace, without reading
+// past it.
+func peekByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+
+		if err != nil {
+			return 0, err
+		}
+
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+// readScannedJSON : Reads the ports of the json formats into scanned, up to the
+// first thing that doesn't parse, which is where a scan that got killed
+// stopped writing.
+func readScannedJSON(reader *bufio.Reader, scanned map[string]struct{}) {
+	decoder := json.NewDecoder(reader)
+
+	// The results of the json-array format are the elements of its array,
+	// those of the json format follow one another.
+	if first, _ := peekByte(reader); first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return
+		}
+	}
+
+	for decoder.More() {
+		var result struct {
+			IP string `json:"ip"`
+			Port uint16 `json:"port"`
+			Error string `json:"error"`
+		}
+
+		if err := decoder.Decode(&result); err != nil {
+			return
+		}
+
+		if result.IP != "" && result.Error == "" {
+			scanned[net.JoinHostPort(result.IP, strconv.Itoa(int(result.Port)))] = struct{}{}
+		}
+	}
+}
+
+// alreadyScanned : Tells whether every one of the ports of ip was scanned in
+// the previous scan.
+func alreadyScanned(scanned map[string]struct{}, ip net.IP, ports []uint16) bool {
+	for _, port := range ports {
+		if _, ok := scanned[net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameFile : Tells whether both paths lead to the same file, which has to
+// exist.
+func sameFile(first string, second string) bool {
+	firstInfo, err := os.Stat(first)
+
+	if err != nil {
+		return false
+	}
+
+	secondInfo, err := os.Stat(second)
+
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(firstInfo, secondInfo)
+}
+
+// openAppend : Opens a previous scan's output in the given format to add to
+// it, starting on a new line in case the last one only got written in part. It
+// tells whether the output already has results to carry on from, which for the
+// json-array format means its array gets opened back up, and for the csv one
+// that its header row is out already.
+func openAppend(path string, format string) (*os.File, bool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR | os.O_APPEND | os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+
+	if info.Size() == 0 {
+		return file, false, nil
+	}
+
+	if format == "json-array" {
+		continued, err := reopenArray(file, info.Size())
+
+		if err != nil {
+			file.Close()
+			return nil, false, err
+		}
+
+		return file, continued, nil
+	}
+
+	last := make([]byte, 1)
+
+	if _, err := file.ReadAt(last, info.Size() - 1); err == nil && last[0] != '\n' {
+		file.Write([]byte("\n"))
+	}
+
+	return file, true, nil
+}
+
+// reopenArray : Strips the end off the array of the json-array format, so that
+// more results can go in it, telling whether it has any results already. The
+// array is cut back to its last full result, since a scan that got killed may
+// have only written part of the one after it. An array without any is dropped
+// altogether, to be started over.
+func reopenArray(file *os.File, size int64) (bool, error) {
+	data := make([]byte, size)
+
+	if _, err := file.ReadAt(data, 0); err != nil {
+		return false, err
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return false, file.Truncate(0)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return false, errors.New("Can't add to a json array that isn't one")
+	}
+
+	// Where the last full result ends.
+	end := int64(0)
+
+	for decoder.More() {
+		var result json.RawMessage
+
+		if err := decoder.Decode(&result); err != nil {
+			break
+		}
+
+		end = decoder.InputOffset()
+	}
+
+	if end == 0 {
+		return false, file.Truncate(0)
+	}
+
+	return true, file.Truncate(end)
+}
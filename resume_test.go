@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// printTo : Writes results into the output at path like a scan resumed into it
+// does, adding to it if it's there already.
+func printTo(t *testing.T, path string, format string, results ...scanner.Result) {
+	file, continued, err := openAppend(path, format)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	printer, err := NewPrinter(file, format, continued)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printer.Print(results)
+	printer.Close()
+}
+
+// testResults are the results of a couple of hosts.
+var testResults = []scanner.Result{
+	{IP: "10.0.0.1", Port: 22, State: scanner.Open, Banner: "SSH-2.0-dropbear_2012.55", Time: time.Now()},
+	{IP: "10.0.0.2", Port: 22, State: scanner.Closed, Time: time.Now()},
+}
+
+func TestResumeJSONArray(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+
+	// A scan that found nothing, and two that did.
+	printTo(t, path, "json-array")
+	printTo(t, path, "json-array", testResults[0])
+	printTo(t, path, "json-array", testResults[1])
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []struct {
+		IP string `json:"ip"`
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+
+	if len(records) != 2 || records[0].IP != "10.0.0.1" || records[1].IP != "10.0.0.2" {
+		t.Errorf("got %s", data)
+	}
+
+	scanned, err := readScanned(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scanned) != 2 {
+		t.Errorf("read back %v", scanned)
+	}
+}
+
+func TestResumeCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+
+	printTo(t, path, "csv", testResults[0])
+	printTo(t, path, "csv", testResults[1])
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The header goes out once, at the top.
+	if len(rows) != 3 || rows[0][0] != "ip" || rows[1][0] != "10.0.0.1" || rows[2][0] != "10.0.0.2" {
+		t.Errorf("got %v", rows)
+	}
+}
+
+func TestReadScanned(t *testing.T) {
+	// A host that didn't answer ARP gets another try.
+	failed := scanner.FailedResults(net.IP{10, 0, 0, 3}, []uint16{22}, fmt.Errorf("%w: no ARP reply within 3s", scanner.ErrNoNeighbor))
+	results := append(append([]scanner.Result(nil), testResults...), failed...)
+
+	for _, format := range []string{"json", "json-array", "csv"} {
+		for _, pretty := range []bool{false, true} {
+			if pretty && format == "csv" {
+				continue
+			}
+
+			path := filepath.Join(t.TempDir(), "results")
+			file, err := os.Create(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			printer, err := NewPrinter(file, format, false)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			printer.Pretty = pretty
+			printer.Print(results)
+			printer.Close()
+			file.Close()
+
+			scanned, err := readScanned(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, first := scanned["10.0.0.1:22"]
+			_, second := scanned["10.0.0.2:22"]
+
+			if len(scanned) != 2 || !first || !second {
+				t.Errorf("%s (pretty %v): read back %v", format, pretty, scanned)
+			}
+		}
+	}
+}
+
+func TestReadScannedPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	data := `{"ip":"10.0.0.1","port":22,"state":"open"}` + "\n" + `{"ip":"10.0.0.2","po`
+
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scanned, err := readScanned(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := scanned["10.0.0.1:22"]; len(scanned) != 1 || !ok {
+		t.Errorf("read back %v", scanned)
+	}
+}
+
+// TestResumeJSONArrayTruncated checks that an array that was cut off inside a
+// result gets cut back to the result before it, even when what's left of the
+// one it was cut off in ends with an object of its own.
+func TestResumeJSONArrayTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	partial := "[\n" + `{"ip":"10.0.0.1","port":22,"state":"open"},` + "\n" + `{"ip":"10.0.0.2","port":443,"tls":{"version":"TLS 1.3"}`
+
+	if err := os.WriteFile(path, []byte(partial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	printTo(t, path, "json-array", testResults[1])
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []struct {
+		IP string `json:"ip"`
+		Port uint16 `json:"port"`
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+
+	if len(records) != 2 || records[0].IP != "10.0.0.1" || records[1].IP != "10.0.0.2" || records[1].Port != 22 {
+		t.Errorf("got %s", data)
+	}
+}
@@ -0,0 +1,196 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBannerTimeout is how long a server gets to send its banner unless
+// told otherwise.
+const DefaultBannerTimeout = time.Second * 5
+
+// maxBannerLines is how many lines we read looking for the SSH identification
+// string, since servers may send other lines before it.
+const maxBannerLines = 16
+
+// Banner : Connects to the given port and reads the banner of the service. For
+// SSH servers that's the full identification string (SSH-2.0-...), for other
+// services the first line they send, and for TLS ones a summary of the
+// handshake, unless the port has a prober of its own. It fails if no
+// connection can be made.
+func (scanner *Scanner) Banner(ctx context.Context, port uint16) (string, error) {
+	banner, _, err := scanner.banner(ctx, port)
+
+	return banner, err
+}
+
+// banner : Connects to the given port and grabs its banner, along with what
+// its TLS handshake told us if it speaks TLS.
+func (scanner *Scanner) banner(ctx context.Context, port uint16) (string, *TLSInfo, error) {
+	// Connecting gets as long as reading the banner does.
+	conn, closeConn, err := scanner.Dials.dial(ctx, net.JoinHostPort(scanner.DestIP.String(), strconv.Itoa(int(port))), scanner.bannerTimeout())
+
+	// The port answered our SYN but won't take a real connection, so there's
+	// nothing to read from.
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Don't leak a socket for every open port found.
+	defer closeConn()
+
+	banner, info := scanner.grab(conn, port)
+
+	return banner, info, nil
+}
+
+// Prober grabs the banner of a service, once a connection to its port has
+// been made. Probers can read the banner the service sends on its own, or send
+// it something to get an answer out of it first.
+type Prober interface {
+	Probe(conn net.Conn) (string, error)
+}
+
+// ProberFunc lets an ordinary function be used as a Prober.
+type ProberFunc func(conn net.Conn) (string, error)
+
+// Probe : Calls the function.
+func (probe ProberFunc) Probe(conn net.Conn) (string, error) {
+	return probe(conn)
+}
+
+var (
+	// SSHProber reads the SSH identification string, or the first line sent
+	// by services other than SSH. It's what banners are grabbed with unless
+	// told otherwise.
+	SSHProber Prober = ProberFunc(readBanner)
+
+	// HTTPProber sends a HEAD request, and takes the status line and Server
+	// header of the response.
+	HTTPProber Prober = ProberFunc(readHTTP)
+)
+
+// grab : Grabs the banner of the service on the other end of conn with the
+// prober of its port, doing a TLS handshake first for the ports that usually
+// speak TLS, or every port if TLS is set. Without a prober of their own, TLS
+// ports get a summary of the handshake as their banner.
+func (scanner *Scanner) grab(conn net.Conn, port uint16) (string, *TLSInfo) {
+	// Slow servers don't get to hold on to the connection forever, whatever
+	// is being grabbed.
+	conn.SetDeadline(time.Now().Add(scanner.bannerTimeout()))
+
+	prober := scanner.Probers[port]
+
+	if prober == nil && scanner.HTTP {
+		prober = HTTPProber
+	}
+
+	var info *TLSInfo
+
+	if scanner.TLS || tlsPorts[port] {
+		tlsConn, tlsInfo, err := readTLS(conn)
+
+		if err != nil {
+			return "Unable to complete TLS handshake", nil
+		}
+
+		if prober == nil {
+			return tlsInfo.String(), tlsInfo
+		}
+
+		// The prober talks to the service over TLS then.
+		conn, info = tlsConn, tlsInfo
+	}
+
+	if prober == nil {
+		prober = SSHProber
+	}
+
+	banner, err := prober.Probe(conn)
+
+	if err != nil {
+		return "Unable to get banner", info
+	}
+
+	return banner, info
+}
+
+// bannerTimeout : Returns how long a server gets to send its banner.
+func (scanner *Scanner) bannerTimeout() time.Duration {
+	if scanner.BannerTimeout <= 0 {
+		return DefaultBannerTimeout
+	}
+
+	return scanner.BannerTimeout
+}
+
+// readBanner : Reads the banner of the service on the other end of conn.
+func readBanner(conn net.Conn) (string, error) {
+	// Slow servers may send the banner in bits and pieces, which the buffered
+	// reader stitches back together.
+	connbuf := bufio.NewReader(conn)
+	data := ""
+
+	for i := 0; i < maxBannerLines; i++ {
+		str, err := connbuf.ReadString('\n')
+		str = strings.TrimRight(str, "\r\n")
+
+		// This is the identification string we're after.
+		if strings.HasPrefix(str, "SSH-") {
+			return str, nil
+		}
+
+		// Otherwise remember the first thing that was said, in case this is
+		// no SSH server at all.
+		if data == "" {
+			data = str
+		}
+
+		if err != nil {
+			if data == "" {
+				return "", err
+			}
+
+			break
+		}
+	}
+
+	if data == "" {
+		return "", errors.New("No banner sent")
+	}
+
+	return data, nil
+}
+
+// ParseBanner : Splits an SSH identification string, such as
+// "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13", into the protocol version, the
+// software version and the comments after them. Everything is empty if the
+// banner isn't an identification string.
+func ParseBanner(banner string) (protocol, software, comments string) {
+	rest, ok := strings.CutPrefix(banner, "SSH-")
+
+	if !ok {
+		return "", "", ""
+	}
+
+	// The protocol version can't have a dash in it, the software version can't
+	// have a space in it.
+	protocol, rest, ok = strings.Cut(rest, "-")
+
+	if !ok || protocol == "" {
+		return "", "", ""
+	}
+
+	software, comments, _ = strings.Cut(rest, " ")
+
+	if software == "" {
+		return "", "", ""
+	}
+
+	return protocol, software, comments
+}
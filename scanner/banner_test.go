@@ -0,0 +1,279 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseBanner(t *testing.T) {
+	for _, c := range []struct {
+		banner string
+		protocol, software, comments string
+	}{
+		{"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13", "2.0", "OpenSSH_9.6p1", "Ubuntu-3ubuntu13"},
+		{"SSH-2.0-OpenSSH_7.4p1 Raspbian-10+deb9u3", "2.0", "OpenSSH_7.4p1", "Raspbian-10+deb9u3"},
+		{"SSH-2.0-OpenSSH_8.0", "2.0", "OpenSSH_8.0", ""},
+		{"SSH-2.0-dropbear_2012.55", "2.0", "dropbear_2012.55", ""},
+		{"SSH-1.99-Cisco-1.25", "1.99", "Cisco-1.25", ""},
+		{"SSH-2.0-ROSSSH", "2.0", "ROSSSH", ""},
+		{"SSH-2.0-libssh_0.9.6", "2.0", "libssh_0.9.6", ""},
+		{"SSH-2.0-Go", "2.0", "Go", ""},
+		{"SSH-2.0-mod_sftp/0.9.9 (ProFTPD)", "2.0", "mod_sftp/0.9.9", "(ProFTPD)"},
+		{"SSH-2.0-OpenSSH_for_Windows_8.1", "2.0", "OpenSSH_for_Windows_8.1", ""},
+		{"SSH-2.0-", "", "", ""},
+		{"SSH--OpenSSH_9.6", "", "", ""},
+		{"SSH-2.0", "", "", ""},
+		{"220 mail.example.com ESMTP Postfix", "", "", ""},
+		{"HTTP/1.0 200 OK", "", "", ""},
+		{"", "", "", ""},
+	} {
+		protocol, software, comments := ParseBanner(c.banner)
+
+		if protocol != c.protocol || software != c.software || comments != c.comments {
+			t.Errorf("%q: got %q, %q, %q, want %q, %q, %q", c.banner, protocol, software, comments, c.protocol, c.software, c.comments)
+		}
+	}
+}
+
+// TestBannerSplit checks that a banner sent in bits, after a line that isn't
+// the identification string, is read in full, and that services other than
+// SSH get the first line they send.
+func TestBannerSplit(t *testing.T) {
+	port := listen(t, "127.0.0.2", func(conn net.Conn) {
+		conn.Write([]byte("Welcome\r\nSSH-2.0-Open"))
+		time.Sleep(time.Millisecond * 50)
+		conn.Write([]byte("SSH_9.6p1 Ubuntu-3ubuntu13\r\n"))
+	})
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{port}, fakeEthernet, nil)
+	banner, err := scanner.Banner(context.Background(), port)
+
+	if err != nil || banner != "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13" {
+		t.Errorf("got %q, %v", banner, err)
+	}
+
+	port = listen(t, "127.0.0.2", func(conn net.Conn) {
+		conn.Write([]byte("220 mail.example.com"))
+		time.Sleep(time.Millisecond * 50)
+		conn.Write([]byte(" ESMTP Postfix\r\n"))
+	})
+
+	if banner, err := scanner.Banner(context.Background(), port); err != nil || banner != "220 mail.example.com ESMTP Postfix" {
+		t.Errorf("got %q, %v", banner, err)
+	}
+}
+
+// TestBannerTimeout checks that a server sending its banner too late doesn't
+// hold on to the scan past the BannerTimeout.
+func TestBannerTimeout(t *testing.T) {
+	port := listenSlowSSH(t, "127.0.0.2", time.Second)
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{port}, fakeEthernet, nil)
+	scanner.BannerTimeout = time.Millisecond * 100
+
+	start := time.Now()
+	banner, err := scanner.Banner(context.Background(), port)
+
+	if err != nil || banner != "Unable to get banner" {
+		t.Errorf("got %q, %v", banner, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Millisecond * 500 {
+		t.Errorf("took %v, want about %v", elapsed, scanner.BannerTimeout)
+	}
+
+	// A banner in time still makes it.
+	scanner.BannerTimeout = time.Second * 2
+
+	if banner, err := scanner.Banner(context.Background(), port); err != nil || banner != "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13" {
+		t.Errorf("got %q, %v", banner, err)
+	}
+}
+
+// TestProber checks that the prober of a port grabs its banner, and only its
+// banner.
+func TestProber(t *testing.T) {
+	smtp := listen(t, "127.0.0.2", func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+
+		if line, _ := reader.ReadString('\n'); line == "EHLO shellscan\r\n" {
+			conn.Write([]byte("250-mail.example.com\r\n250 STARTTLS\r\n"))
+		}
+	})
+
+	ssh := listenSSH(t, "127.0.0.2")
+
+	probed := 0
+	var mutex sync.Mutex
+
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {smtp: portOpen, ssh: portOpen}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{smtp, ssh}, fakeEthernet, link)
+	scanner.Probers = map[uint16]Prober{
+		smtp: ProberFunc(func(conn net.Conn) (string, error) {
+			mutex.Lock()
+			probed++
+			mutex.Unlock()
+
+			reader := bufio.NewReader(conn)
+
+			if _, err := reader.ReadString('\n'); err != nil {
+				return "", err
+			}
+
+			conn.Write([]byte("EHLO shellscan\r\n"))
+			lines := []string{}
+
+			for {
+				line, err := reader.ReadString('\n')
+
+				if err != nil {
+					return "", err
+				}
+
+				lines = append(lines, strings.TrimSpace(line))
+
+				if strings.HasPrefix(line, "250 ") {
+					return strings.Join(lines, " "), nil
+				}
+			}
+		}),
+	}
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint16]string{
+		smtp: "250-mail.example.com 250 STARTTLS",
+		ssh: "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13",
+	}
+
+	for _, result := range results {
+		if result.State != Open || result.Banner != want[result.Port] {
+			t.Errorf("port %d: got %v, banner %q, want %q", result.Port, result.State, result.Banner, want[result.Port])
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if probed != 1 {
+		t.Errorf("prober ran %d times, want once", probed)
+	}
+}
+
+// openFiles : Returns how many files the process has open, telling whether it
+// could tell.
+func openFiles() (int, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+
+	return len(entries), err == nil
+}
+
+// TestBannerNoLeaks checks that grabbing banners, or failing to connect to
+// grab them, doesn't leave sockets open.
+func TestBannerNoLeaks(t *testing.T) {
+	if _, ok := openFiles(); !ok {
+		t.Skip("can't count the open files of the process")
+	}
+
+	open := listenSSH(t, "127.0.0.2")
+
+	// A port nothing listens on anymore, which refuses connections.
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open, closed}, fakeEthernet, nil)
+	before, _ := openFiles()
+
+	for i := 0; i < 200; i++ {
+		if banner, err := scanner.Banner(context.Background(), open); err != nil || banner != "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13" {
+			t.Fatalf("open port: got %q, %v", banner, err)
+		}
+
+		if _, err := scanner.Banner(context.Background(), closed); err == nil {
+			t.Fatal("closed port: got no error")
+		}
+	}
+
+	// The server closes its end on its own time, so give it a moment.
+	deadline := time.Now().Add(time.Second)
+	after, _ := openFiles()
+
+	for after > before + 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+		after, _ = openFiles()
+	}
+
+	if after > before + 5 {
+		t.Errorf("got %d open files after 400 grabs, up from %d", after, before)
+	}
+}
+
+// TestDialLimiter grabs the banners of many listeners at once, and checks that
+// no more connections than the limit are open at any time.
+func TestDialLimiter(t *testing.T) {
+	var mutex sync.Mutex
+	active, most := 0, 0
+
+	ports := make([]uint16, 20)
+
+	for i := range ports {
+		ports[i] = listen(t, "127.0.0.2", func(conn net.Conn) {
+			mutex.Lock()
+			active++
+			most = max(most, active)
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond * 5)
+
+			mutex.Lock()
+			active--
+			mutex.Unlock()
+
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1\r\n"))
+		})
+	}
+
+	dials := NewDialLimiter(4)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 200; i++ {
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, ports, fakeEthernet, nil)
+		scanner.Dials = dials
+		port := ports[i % len(ports)]
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if banner, err := scanner.Banner(context.Background(), port); err != nil || banner != "SSH-2.0-OpenSSH_9.6p1" {
+				t.Errorf("port %d: got %q, %v", port, banner, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if most > 4 {
+		t.Errorf("got %d connections at once, want at most 4", most)
+	}
+}
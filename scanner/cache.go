@@ -0,0 +1,140 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// MACCache remembers the network addresses resolved through ARP or neighbor
+// discovery, keyed on the IP address that was asked for. It's safe to share
+// between scanners.
+type MACCache struct {
+	// How long an address is remembered for.
+	TTL time.Duration
+
+	// How many are holding on to the entries, which don't expire until they
+	// all let go.
+	holds int
+
+	entries map[string]*macEntry
+	mutex sync.Mutex
+}
+
+// macEntry is a resolved address, or one that's still being resolved.
+type macEntry struct {
+	hwaddr net.HardwareAddr
+	err error
+	expires time.Time
+
+	// Closed once the address has been resolved.
+	ready chan struct{}
+}
+
+// NewMACCache : Creates an empty cache whose entries expire after the TTL.
+func NewMACCache(ttl time.Duration) *MACCache {
+	return &MACCache{
+		TTL: ttl,
+		entries: make(map[string]*macEntry),
+	}
+}
+
+// Resolve : Returns the cached address of ip, or resolves it with the given
+// function if it isn't known yet. Scanners asking for an address that's being
+// resolved already wait for that instead of asking again. Only a host not
+// answering is passed on to them: when resolving it failed some other way,
+// such as the scanner resolving it running out of time or being cancelled,
+// they try for themselves.
+func (cache *MACCache) Resolve(ctx context.Context, ip net.IP, resolve func() (net.HardwareAddr, error)) (net.HardwareAddr, error) {
+	key := ip.String()
+
+	for {
+		cache.mutex.Lock()
+		entry, ok := cache.entries[key]
+
+		if ok {
+			select {
+			case <-entry.ready:
+				// Forget about the address once it's gone stale.
+				if cache.holds == 0 && time.Now().After(entry.expires) {
+					ok = false
+				}
+			default:
+			}
+		}
+
+		if ok {
+			cache.mutex.Unlock()
+
+			// Someone else is resolving it, so wait for them.
+			select {
+			case <-entry.ready:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			if entry.err == nil || errors.Is(entry.err, ErrNoNeighbor) {
+				return entry.hwaddr, entry.err
+			}
+
+			continue
+		}
+
+		entry = &macEntry{ready: make(chan struct{})}
+		cache.entries[key] = entry
+		cache.mutex.Unlock()
+
+		entry.hwaddr, entry.err = resolve()
+		entry.expires = time.Now().Add(cache.TTL)
+
+		// Failures aren't remembered, the next scanner will just try again.
+		if entry.err != nil {
+			cache.mutex.Lock()
+
+			if cache.entries[key] == entry {
+				delete(cache.entries, key)
+			}
+
+			cache.mutex.Unlock()
+		}
+
+		close(entry.ready)
+
+		return entry.hwaddr, entry.err
+	}
+}
+
+// Hold : Keeps every entry from expiring until Release is called, such as for
+// the addresses resolved ahead of a scan, which would otherwise go stale before
+// the last hosts get scanned and have to be resolved all over again.
+func (cache *MACCache) Hold() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.holds++
+}
+
+// Release : Lets go of the entries held by Hold. Once nothing holds them
+// anymore, they expire a TTL from now.
+func (cache *MACCache) Release() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.holds--
+
+	if cache.holds > 0 {
+		return
+	}
+
+	expires := time.Now().Add(cache.TTL)
+
+	for _, entry := range cache.entries {
+		select {
+		case <-entry.ready:
+			entry.expires = expires
+		default:
+		}
+	}
+}
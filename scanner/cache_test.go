@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestMACCacheWaiters checks that scanners waiting on an address another one
+// is resolving get its answer when the host didn't answer, and try for
+// themselves when the other one gave up on its own, such as on being
+// cancelled.
+func TestMACCacheWaiters(t *testing.T) {
+	ip := net.IP{127, 0, 0, 2}
+	hwaddr := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	noNeighbor := fmt.Errorf("%w: no ARP reply within 3s", ErrNoNeighbor)
+
+	tests := []struct {
+		name string
+		err error
+		cancel bool
+		resolves bool
+		want error
+	}{
+		{"host didn't answer", noNeighbor, false, false, noNeighbor},
+		{"resolver cancelled", context.Canceled, true, true, nil},
+		{"resolver timed out", context.DeadlineExceeded, true, true, nil},
+		{"send failed", errors.New("Network is down"), false, true, nil},
+	}
+
+	for _, test := range tests {
+		cache := NewMACCache(time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		started := make(chan struct{})
+		release := make(chan struct{})
+
+		// The first scanner resolves the address, and fails once let go.
+		first := make(chan error)
+
+		go func() {
+			_, err := cache.Resolve(ctx, ip, func() (net.HardwareAddr, error) {
+				close(started)
+				<-release
+				return nil, test.err
+			})
+
+			first <- err
+		}()
+
+		<-started
+
+		// The second one waits on it.
+		resolved := false
+		second := make(chan error)
+
+		go func() {
+			got, err := cache.Resolve(context.Background(), ip, func() (net.HardwareAddr, error) {
+				resolved = true
+				return hwaddr, nil
+			})
+
+			if err == nil && !bytes.Equal(got, hwaddr) {
+				err = fmt.Errorf("got address %v, want %v", got, hwaddr)
+			}
+
+			second <- err
+		}()
+
+		time.Sleep(time.Millisecond * 50)
+
+		if test.cancel {
+			cancel()
+		}
+
+		close(release)
+
+		if err := <-first; !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v for the first scanner, want %v", test.name, err, test.err)
+		}
+
+		if err := <-second; !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v for the second scanner, want %v", test.name, err, test.want)
+		}
+
+		if resolved != test.resolves {
+			t.Errorf("%s: second scanner resolved the address itself: %v, want %v", test.name, resolved, test.resolves)
+		}
+
+		cancel()
+	}
+}
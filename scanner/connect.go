@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// connectScan : Scans the DestPorts by connecting to them, filling in the
+// given results. Refused connections are closed ports, connections that time
+// out are filtered ones. Ports the HostTimeout ran out before get
+// ErrHostTimeout as their error.
+func (scanner *Scanner) connectScan(ctx context.Context, parent context.Context, results []Result) ([]Result, error) {
+	tried := 0
+
+	for i := range results {
+		result := &results[i]
+
+		// Connections count against the rate like probes do.
+		if scanner.Limiter != nil {
+			if err := scanner.Limiter.Wait(ctx); err != nil {
+				break
+			}
+		}
+
+		// Give every connection as long as raw probes get to answer.
+		dialCtx, cancel := context.WithTimeout(ctx, scanner.Idle.shorten(timeout(scanner.ScanTimeout)))
+		sent := time.Now()
+
+		conn, closeConn, err := scanner.Dials.dial(dialCtx, net.JoinHostPort(scanner.DestIP.String(), strconv.Itoa(int(result.Port))), 0)
+		cancel()
+		tried++
+
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				result.State = Closed
+				result.Reason = "conn-refused"
+				result.RTT = time.Since(sent)
+			}
+
+			scanner.logger().Debug("Unable to connect", "ip", result.IP, "port", result.Port, "err", err)
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			continue
+		}
+
+		result.State = Open
+		result.Reason = "syn-ack"
+		result.RTT = time.Since(sent)
+		banner, info := scanner.grab(conn, result.Port)
+		result.setBanner(banner)
+		result.TLS = info
+		closeConn()
+	}
+
+	// Running out of the host budget isn't a failure, being cancelled is.
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+
+	// The ports that never got a connection attempt can't be told apart from
+	// filtered ones.
+	if tried == 0 && len(results) > 0 {
+		return FailedResults(scanner.DestIP, scanner.DestPorts, ErrHostTimeout), ErrHostTimeout
+	}
+
+	for i := range results[tried:] {
+		results[tried + i].Error = ErrHostTimeout.Error()
+	}
+
+	return results, nil
+}
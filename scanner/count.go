@@ -0,0 +1,149 @@
+package scanner
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// countScan : Sends Count probes to every port, one every RetransmitInterval,
+// and counts how many of them got a reply. The probes are numbered through
+// their sequence numbers, counting up from the one of their port, which replies
+// acknowledge, so that duplicate replies aren't counted twice. Open ports get
+// their banner grabbed once we're done, even when the host ran out of time,
+// which waiting out the probes takes most of.
+func (scanner *Scanner) countScan(ctx context.Context, parent context.Context, results []Result, probes map[probeKey]*Result, seqs map[probeKey]uint32, eth *layers.Ethernet, ip ipLayer, netFlow gopacket.Flow) ([]Result, error) {
+	// The probes every port replied to, by number.
+	replied := make(map[probeKey]map[uint32]bool, len(probes))
+
+	for key := range probes {
+		replied[key] = map[uint32]bool{}
+	}
+
+	lastSent := time.Time{}
+	sends := 0
+
+	for {
+		// Every port gets every probe, whether it answered the last ones or
+		// not.
+		if sends < scanner.Count && (sends == 0 || time.Since(lastSent) >= scanner.retransmitInterval()) {
+			for key, result := range probes {
+				if err := scanner.sendProbe(ctx, eth, ip, key, seqs[key] + uint32(sends)); err != nil {
+					scanner.logger().Warn("Error sending probe", "ip", scanner.DestIP, "port", result.Port, "err", err)
+					result.Error = err.Error()
+				}
+			}
+
+			lastSent = time.Now()
+			sends++
+		}
+
+		// Has the scan been cancelled, or has the host run out of time?
+		if err := ctx.Err(); err != nil {
+			if parent.Err() != nil {
+				return nil, err
+			}
+
+			break
+		}
+
+		// The last probe had its time to answer.
+		if sends == scanner.Count && time.Since(lastSent) > scanner.scanTimeout() {
+			break
+		}
+
+		data, info, err := scanner.Handle.ReadPacketData()
+
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			scanner.logger().Warn("Error reading packet", "ip", scanner.DestIP, "err", err)
+			continue
+		}
+
+		packets := scanner.decoder()
+		packets.decode(data)
+
+		// ICMP errors don't count as replies, but they do tell why a port
+		// stays quiet.
+		if dst, key, code, ok := packets.unreachable(); ok {
+			if result, probed := probes[key]; probed && dst.Equal(scanner.DestIP) && len(replied[key]) == 0 {
+				result.State = Filtered
+				result.Unreachable = code
+				result.Reason = "icmp-" + code
+			}
+
+			continue
+		}
+
+		flow, ok := packets.networkFlow()
+		tcp := &packets.tcp
+
+		if !ok || flow != netFlow || !packets.has(layers.LayerTypeTCP) {
+			continue
+		}
+
+		key := probeKey{tcp.DstPort, tcp.SrcPort}
+		result, probed := probes[key]
+
+		// Replies acknowledge the number of the probe, plus one for the SYN or
+		// FIN flag of the probe if it had one.
+		number := tcp.Ack - scanner.ScanType.acked(seqs[key])
+
+		if !probed || number >= uint32(sends) || replied[key][number] {
+			continue
+		}
+
+		if scanner.ScanType == SYNScan && tcp.SYN && tcp.ACK {
+			result.State = Open
+			result.Reason = "syn-ack"
+			result.Tarpit = isTarpit(tcp)
+
+			// Every probe leaves a half-open connection of its own behind.
+			if scanner.Reset {
+				if err := scanner.sendReset(ctx, eth, ip, tcp); err != nil {
+					scanner.logger().Debug("Error sending reset", "ip", result.IP, "port", result.Port, "err", err)
+				}
+			}
+		} else if tcp.RST {
+			result.State = Closed
+			result.Reason = "reset"
+		} else {
+			continue
+		}
+
+		scanner.Metrics.received()
+		replied[key][number] = true
+
+		result.Error = ""
+		result.Unreachable = ""
+
+		// Only the reply to the last probe can be timed.
+		if number == uint32(sends - 1) {
+			result.RTT = since(lastSent, info)
+		}
+	}
+
+	for key, result := range probes {
+		result.Count = &ProbeCount{Sent: sends, Replied: len(replied[key])}
+
+		if result.State != Open || (result.Tarpit && scanner.SkipTarpits) {
+			continue
+		}
+
+		// The budget of the host is likely gone by now, so the banners only
+		// get the time of their own.
+		if banner, info, err := scanner.banner(parent, result.Port); err != nil {
+			scanner.logger().Debug("Unable to connect", "ip", result.IP, "port", result.Port, "err", err)
+			result.State = DialFailed
+		} else {
+			result.setBanner(banner)
+			result.TLS = info
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,87 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// TestCountScan checks that lost probes are counted as such, that replies keep
+// being counted after the first one, that the half-open connections they
+// leave behind get reset, and that open ports still get their banner after
+// the host ran out of time waiting out the probes.
+func TestCountScan(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+	answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {open: portOpen, 2201: portClosed}})
+
+	resets := 0
+	probes := map[layers.TCPPort]int{}
+	var mutex sync.Mutex
+
+	link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+		if packets.has(layers.LayerTypeTCP) && packets.tcp.RST {
+			mutex.Lock()
+			resets++
+			mutex.Unlock()
+
+			return nil
+		}
+
+		// The second probe to every port gets lost.
+		if packets.has(layers.LayerTypeTCP) {
+			mutex.Lock()
+			probes[packets.tcp.DstPort]++
+			lost := probes[packets.tcp.DstPort] == 2
+			mutex.Unlock()
+
+			if lost {
+				return nil
+			}
+		}
+
+		return answer(data, packets)
+	})
+
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open, 2201}, fakeEthernet, link)
+	scanner.Count = 3
+	scanner.RetransmitInterval = time.Millisecond * 20
+	scanner.ScanTimeout = time.Second * 5
+	scanner.HostTimeout = time.Millisecond * 300
+	scanner.Reset = true
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.Count == nil || result.Count.Sent != 3 || result.Count.Replied != 2 {
+			t.Errorf("port %d: got count %+v, want 2 of 3", result.Port, result.Count)
+		}
+
+		switch result.Port {
+		case open:
+			if result.State != Open || result.Software != "OpenSSH_9.6p1" {
+				t.Errorf("open port: got %v, banner %q", result.State, result.Banner)
+			}
+		default:
+			if result.State != Closed {
+				t.Errorf("closed port: got %v", result.State)
+			}
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if resets != 2 {
+		t.Errorf("sent %d resets, want one for every SYN/ACK", resets)
+	}
+}
@@ -0,0 +1,209 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// decoder parses packets into layers it keeps around between packets, so that
+// reading replies doesn't allocate a packet and its layers for every one of
+// them. The layers point into the data that was decoded, and only hold the
+// last packet. It's not safe for concurrent use.
+type decoder struct {
+	eth layers.Ethernet
+	dot1q layers.Dot1Q
+	arp layers.ARP
+	ip4 layers.IPv4
+	ip6 layers.IPv6
+	tcp layers.TCP
+	icmp4 layers.ICMPv4
+	icmp6 layers.ICMPv6
+	echo6 layers.ICMPv6Echo
+	advert layers.ICMPv6NeighborAdvertisement
+	payload gopacket.Payload
+
+	parser *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+}
+
+// newDecoder : Creates a decoder for Ethernet frames.
+func newDecoder() *decoder {
+	d := &decoder{}
+
+	d.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+		&d.eth, &d.dot1q, &d.arp, &d.ip4, &d.ip6, &d.tcp, &d.icmp4, &d.icmp6, &d.echo6, &d.advert, &d.payload)
+
+	// Whatever we don't decode isn't something we're waiting for.
+	d.parser.IgnoreUnsupported = true
+
+	return d
+}
+
+// decode : Parses a packet, telling whether it could be. Even packets that
+// couldn't be parsed in full may have some of their layers decoded.
+func (d *decoder) decode(data []byte) bool {
+	return d.parser.DecodeLayers(data, &d.decoded) == nil
+}
+
+// has : Tells whether the last packet had a layer of the given type.
+func (d *decoder) has(layerType gopacket.LayerType) bool {
+	for _, decoded := range d.decoded {
+		if decoded == layerType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// networkFlow : Returns the IPv4 or IPv6 flow of the last packet, telling
+// whether it had one.
+func (d *decoder) networkFlow() (gopacket.Flow, bool) {
+	if d.has(layers.LayerTypeIPv4) {
+		return d.ip4.NetworkFlow(), true
+	}
+
+	if d.has(layers.LayerTypeIPv6) {
+		return d.ip6.NetworkFlow(), true
+	}
+
+	return gopacket.Flow{}, false
+}
+
+// senderAddress : Returns the address that sent the last packet, as far as the
+// scanners are concerned.
+func (d *decoder) senderAddress() string {
+	// ARP replies and neighbor advertisements are about the address that was
+	// asked for.
+	if d.has(layers.LayerTypeARP) {
+		return net.IP(d.arp.SourceProtAddress).String()
+	}
+
+	if d.has(layers.LayerTypeICMPv6NeighborAdvertisement) {
+		return d.advert.TargetAddress.String()
+	}
+
+	// So are the ICMP errors about our probes, which come from whatever
+	// router or firewall is in the way.
+	if dst, _, _, ok := d.unreachable(); ok {
+		return dst.String()
+	}
+
+	// Everything else is about whoever sent it.
+	if flow, ok := d.networkFlow(); ok {
+		return net.IP(flow.Src().Raw()).String()
+	}
+
+	return ""
+}
+
+// echoReply : Tells whether the last packet was an ICMP (or ICMPv6) echo reply
+// from the given address to the echo request with the given ID.
+func (d *decoder) echoReply(src net.IP, id uint16) bool {
+	if d.has(layers.LayerTypeICMPv4) && d.has(layers.LayerTypeIPv4) {
+		return d.icmp4.TypeCode.Type() == layers.ICMPv4TypeEchoReply && d.icmp4.Id == id && d.ip4.SrcIP.Equal(src)
+	}
+
+	if d.has(layers.LayerTypeICMPv6Echo) && d.has(layers.LayerTypeIPv6) {
+		return d.icmp6.TypeCode.Type() == layers.ICMPv6TypeEchoReply && d.echo6.Identifier == id && d.ip6.SrcIP.Equal(src)
+	}
+
+	return false
+}
+
+// unreachable : Tells whether the last packet was an ICMP destination
+// unreachable error about a TCP packet, and returns the destination and ports
+// of that packet, as quoted in the error, and what the code of the error says.
+func (d *decoder) unreachable() (net.IP, probeKey, string, bool) {
+	var quoted []byte
+	var dst net.IP
+	var code string
+
+	switch {
+	case d.has(layers.LayerTypeICMPv4) && d.icmp4.TypeCode.Type() == layers.ICMPv4TypeDestinationUnreachable:
+		// The error quotes the IPv4 header of the packet, along with the
+		// start of what it carried.
+		quoted = d.icmp4.Payload
+
+		if len(quoted) < 20 || quoted[9] != byte(layers.IPProtocolTCP) {
+			return nil, probeKey{}, "", false
+		}
+
+		headerLength := int(quoted[0] & 0x0f) * 4
+
+		if headerLength < 20 || len(quoted) < headerLength {
+			return nil, probeKey{}, "", false
+		}
+
+		dst = net.IP(quoted[16:20])
+		quoted = quoted[headerLength:]
+		code = unreachableCode4(d.icmp4.TypeCode.Code())
+	case d.has(layers.LayerTypeICMPv6) && d.icmp6.TypeCode.Type() == layers.ICMPv6TypeDestinationUnreachable:
+		// The quoted IPv6 packet comes after 4 unused bytes.
+		quoted = d.icmp6.Payload
+
+		if len(quoted) < 44 || quoted[10] != byte(layers.IPProtocolTCP) {
+			return nil, probeKey{}, "", false
+		}
+
+		dst = net.IP(quoted[28:44])
+		quoted = quoted[44:]
+		code = unreachableCode6(d.icmp6.TypeCode.Code())
+	default:
+		return nil, probeKey{}, "", false
+	}
+
+	// Only the ports of the TCP header are needed.
+	if len(quoted) < 4 {
+		return nil, probeKey{}, "", false
+	}
+
+	key := probeKey{
+		src: layers.TCPPort(binary.BigEndian.Uint16(quoted[0:2])),
+		dst: layers.TCPPort(binary.BigEndian.Uint16(quoted[2:4])),
+	}
+
+	return dst, key, code, true
+}
+
+// unreachableCode4 : Names the code of an ICMPv4 destination unreachable error.
+func unreachableCode4(code uint8) string {
+	switch code {
+	case layers.ICMPv4CodeNet:
+		return "net-unreachable"
+	case layers.ICMPv4CodeHost:
+		return "host-unreachable"
+	case layers.ICMPv4CodeProtocol:
+		return "protocol-unreachable"
+	case layers.ICMPv4CodePort:
+		return "port-unreachable"
+	case layers.ICMPv4CodeNetAdminProhibited:
+		return "net-prohibited"
+	case layers.ICMPv4CodeHostAdminProhibited:
+		return "host-prohibited"
+	case layers.ICMPv4CodeCommAdminProhibited:
+		return "admin-prohibited"
+	}
+
+	return fmt.Sprintf("unreachable-%d", code)
+}
+
+// unreachableCode6 : Names the code of an ICMPv6 destination unreachable error.
+func unreachableCode6(code uint8) string {
+	switch code {
+	case layers.ICMPv6CodeNoRouteToDst:
+		return "net-unreachable"
+	case layers.ICMPv6CodeAdminProhibited:
+		return "admin-prohibited"
+	case layers.ICMPv6CodeAddressUnreachable:
+		return "host-unreachable"
+	case layers.ICMPv6CodePortUnreachable:
+		return "port-unreachable"
+	}
+
+	return fmt.Sprintf("unreachable-%d", code)
+}
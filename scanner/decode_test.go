@@ -0,0 +1,64 @@
+package scanner
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// benchmarkFrames : Returns a SYN/ACK and an ICMP unreachable error answering
+// a probe, like they come in off the wire.
+func benchmarkFrames(b *testing.B) map[string][]byte {
+	eth := &layers.Ethernet{SrcMAC: fakeEthernet.HardwareAddr, DstMAC: fakeHostMAC, EthernetType: layers.EthernetTypeIPv4}
+	ip4 := &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP}
+	probe := &layers.TCP{SrcPort: 50000, DstPort: 22, Seq: 1, SYN: true, Window: DefaultWindow}
+	probe.SetNetworkLayerForChecksum(ip4)
+
+	data := serialize(b, eth, ip4, probe)
+
+	return map[string][]byte{
+		"syn-ack": tcpReply(b, eth, ip4, probe, func(tcp *layers.TCP) {
+			tcp.SYN = true
+			tcp.ACK = true
+		}),
+		"unreachable": unreachableReply(b, eth, ip4, data[14:], layers.ICMPv4CodeHost),
+	}
+}
+
+// BenchmarkDecode is how replies get parsed, by a decoder reusing its layers.
+func BenchmarkDecode(b *testing.B) {
+	for name, data := range benchmarkFrames(b) {
+		b.Run(name, func(b *testing.B) {
+			packets := newDecoder()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				packets.decode(data)
+
+				if _, _, _, ok := packets.unreachable(); !ok && !packets.has(layers.LayerTypeTCP) {
+					b.Fatal("reply wasn't decoded")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNewPacket is how replies used to get parsed, by a packet of their
+// own, for comparison.
+func BenchmarkNewPacket(b *testing.B) {
+	for name, data := range benchmarkFrames(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+
+				if packet.Layer(layers.LayerTypeTCP) == nil && packet.Layer(layers.LayerTypeICMPv4) == nil {
+					b.Fatal("reply wasn't decoded")
+				}
+			}
+		})
+	}
+}
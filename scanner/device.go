@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// devices holds the PCAP device name of every interface looked up so far,
+// keyed on the interface name, since listing the devices isn't cheap.
+var devices sync.Map
+
+// deviceName : Returns the name PCAP knows an interface by. On Linux that's
+// the interface name itself, but elsewhere, such as on Windows where devices
+// are named like \Device\NPF_{...}, the device has to be found by the
+// addresses it has.
+func deviceName(name string) (string, error) {
+	if device, ok := devices.Load(name); ok {
+		return device.(string), nil
+	}
+
+	all, err := pcap.FindAllDevs()
+
+	// Without a list to go by, the interface name is the best guess there
+	// is, and opening it tells what went wrong.
+	if err != nil || len(all) == 0 {
+		return name, nil
+	}
+
+	var addrs []net.IP
+
+	if iface, err := net.InterfaceByName(name); err == nil {
+		if ifaceAddrs, err := iface.Addrs(); err == nil {
+			for _, addr := range ifaceAddrs {
+				if ipnet, ok := addr.(*net.IPNet); ok {
+					addrs = append(addrs, ipnet.IP)
+				}
+			}
+		}
+	}
+
+	device, err := matchDevice(name, addrs, all)
+
+	if err != nil {
+		return "", err
+	}
+
+	devices.Store(name, device)
+
+	return device, nil
+}
+
+// matchDevice : Picks the device of an interface out of the devices PCAP
+// lists, going by its name first and by the addresses it has otherwise.
+func matchDevice(name string, addrs []net.IP, all []pcap.Interface) (string, error) {
+	for _, device := range all {
+		if device.Name == name {
+			return device.Name, nil
+		}
+	}
+
+	for _, device := range all {
+		for _, deviceAddr := range device.Addresses {
+			for _, addr := range addrs {
+				if deviceAddr.IP.Equal(addr) {
+					return device.Name, nil
+				}
+			}
+		}
+	}
+
+	names := make([]string, len(all))
+
+	for i, device := range all {
+		names[i] = device.Name
+
+		if device.Description != "" {
+			names[i] += " (" + device.Description + ")"
+		}
+	}
+
+	return "", fmt.Errorf("No PCAP device for interface %s, the devices are: %s", name, strings.Join(names, ", "))
+}
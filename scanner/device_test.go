@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/pcap"
+)
+
+func TestMatchDevice(t *testing.T) {
+	// How Npcap lists the devices on Windows, and libpcap on Linux.
+	windows := []pcap.Interface{
+		{Name: `\Device\NPF_Loopback`, Description: "Adapter for loopback traffic capture", Addresses: []pcap.InterfaceAddress{{IP: net.ParseIP("127.0.0.1")}}},
+		{Name: `\Device\NPF_{2F6C8A1E-0000-4A6B-9D61-5E2C1B7D3E41}`, Description: "Intel(R) Ethernet Connection", Addresses: []pcap.InterfaceAddress{
+			{IP: net.ParseIP("fe80::1c2b:3d4e:5f60:7182")},
+			{IP: net.ParseIP("192.168.1.20")},
+		}},
+	}
+
+	linux := []pcap.Interface{
+		{Name: "eth0", Addresses: []pcap.InterfaceAddress{{IP: net.ParseIP("192.168.1.20")}}},
+		{Name: "any"},
+	}
+
+	tests := []struct {
+		name string
+		iface string
+		addrs []net.IP
+		all []pcap.Interface
+		want string
+	}{
+		{"same name", "eth0", nil, linux, "eth0"},
+		{"name wins over addresses", "any", []net.IP{net.ParseIP("192.168.1.20")}, linux, "any"},
+		{"by IPv4 address", "Ethernet", []net.IP{net.ParseIP("192.168.1.20")}, windows, `\Device\NPF_{2F6C8A1E-0000-4A6B-9D61-5E2C1B7D3E41}`},
+		{"by IPv6 address", "Ethernet", []net.IP{net.ParseIP("fe80::1c2b:3d4e:5f60:7182")}, windows, `\Device\NPF_{2F6C8A1E-0000-4A6B-9D61-5E2C1B7D3E41}`},
+		{"by the second address", "Loopback Pseudo-Interface 1", []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}, windows, `\Device\NPF_Loopback`},
+		{"no match", "Wi-Fi", []net.IP{net.ParseIP("10.0.0.5")}, windows, ""},
+		{"no addresses", "Wi-Fi", nil, windows, ""},
+	}
+
+	for _, test := range tests {
+		device, err := matchDevice(test.iface, test.addrs, test.all)
+
+		if test.want == "" {
+			// The error lists the devices there are to pick from.
+			if err == nil || !strings.Contains(err.Error(), `\Device\NPF_Loopback (Adapter for loopback traffic capture)`) {
+				t.Errorf("%s: got %q, %v, want an error listing the devices", test.name, device, err)
+			}
+
+			continue
+		}
+
+		if err != nil || device != test.want {
+			t.Errorf("%s: got %q, %v, want %q", test.name, device, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialLimiter bounds how many connections scanners have open at once, such as
+// to grab banners, so that large scans don't run out of ephemeral ports. It's
+// safe to share between scanners.
+type DialLimiter struct {
+	slots chan struct{}
+}
+
+// NewDialLimiter : Creates a limiter allowing up to max connections at once.
+func NewDialLimiter(max int) *DialLimiter {
+	return &DialLimiter{slots: make(chan struct{}, max)}
+}
+
+// dial : Connects to address, waiting for a free slot first if a limiter is
+// set, and giving up on connecting after the timeout, unless it's zero. The
+// returned function closes the connection and gives the slot back.
+func (limiter *DialLimiter) dial(ctx context.Context, address string, timeout time.Duration) (net.Conn, func(), error) {
+	if limiter != nil {
+		select {
+		case limiter.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	release := func() {
+		if limiter != nil {
+			<-limiter.slots
+		}
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return conn, func() {
+		// Reset the connection instead of going through TIME_WAIT, which
+		// would tie up its source port for a while after every grab.
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+
+		conn.Close()
+		release()
+	}, nil
+}
@@ -0,0 +1,45 @@
+// Package scanner finds open TCP ports by sending raw SYN packets and grabs
+// the banners of the services behind them, SSH being the one it was made for.
+//
+// Scanning a single host looks like this:
+//
+//	hostScanner, err := scanner.New(net.ParseIP("10.0.0.1"), scanner.Options{
+//		Ports: []uint16{22},
+//	})
+//
+//	if err != nil {
+//		return err
+//	}
+//
+//	defer hostScanner.Close()
+//
+//	results, err := hostScanner.Scan(ctx)
+//
+// Every Result tells whether its port is Open, Closed or Filtered, along with
+// the banner of open ports. Banners are grabbed by a Prober, SSHProber unless
+// Options.Probers has another one for the port, so that services that only
+// answer once spoken to can be scanned too:
+//
+//	opts.Probers = map[uint16]scanner.Prober{
+//		25: scanner.ProberFunc(func(conn net.Conn) (string, error) {
+//			...
+//		}),
+//	}
+//
+// Results can be handed to a callback as every host is done, instead of
+// collecting what Scan returns, such as to send them somewhere as they come
+// in. Scanners running at the same time call it at the same time too:
+//
+//	var mutex sync.Mutex
+//
+//	opts.OnResult = func(result scanner.Result) {
+//		mutex.Lock()
+//		defer mutex.Unlock()
+//		...
+//	}
+//
+// When scanning many hosts, share a single routing.Router and Pool between the
+// scanners through their Options, so that routes are only read once and only
+// one PCAP handle is opened per interface. Sending raw packets requires root
+// or CAP_NET_RAW, connect scans (see Options.Connect) don't need either.
+package scanner
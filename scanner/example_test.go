@@ -0,0 +1,121 @@
+package scanner_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// Scanning an SSH server, here one listening on the loopback interface. Connect
+// scans need neither root nor PCAP, raw scans look the same but for leaving
+// Connect out.
+func ExampleScanner_Scan() {
+	// Stand in for the SSH server.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			fmt.Fprint(conn, "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n")
+			conn.Close()
+		}
+	}()
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	hostScanner, err := scanner.New(net.ParseIP("127.0.0.1"), scanner.Options{
+		Ports: []uint16{port},
+		Connect: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	defer hostScanner.Close()
+
+	results, err := hostScanner.Scan(context.Background())
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, result := range results {
+		fmt.Println(result.State, result.Reason, result.Software)
+		fmt.Println(result.Banner)
+	}
+
+	// Output:
+	// open syn-ack OpenSSH_9.6p1
+	// SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13
+}
+
+// Collecting the results of several hosts scanned at once through a callback,
+// which gets called from the goroutines of the scans, and has to lock what it
+// shares between them.
+func ExampleOptions_onResult() {
+	var mutex sync.Mutex
+	collected := []string{}
+
+	opts := scanner.Options{
+		// Nothing listens on port 1 of loopback addresses.
+		Ports: []uint16{1},
+		Connect: true,
+		OnResult: func(result scanner.Result) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			collected = append(collected, fmt.Sprintf("%s:%d %s", result.IP, result.Port, result.State))
+		},
+	}
+
+	var wg sync.WaitGroup
+
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		hostScanner, err := scanner.New(net.ParseIP(ip), opts)
+
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer hostScanner.Close()
+
+			hostScanner.Scan(context.Background())
+		}()
+	}
+
+	wg.Wait()
+	sort.Strings(collected)
+
+	for _, result := range collected {
+		fmt.Println(result)
+	}
+
+	// Output:
+	// 127.0.0.1:1 closed
+	// 127.0.0.2:1 closed
+	// 127.0.0.3:1 closed
+}
@@ -0,0 +1,57 @@
+package scanner
+
+import (
+	"context"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// fragmentSize is how many bytes of the TCP segment go in every fragment of a
+// fragmented probe, the least IPv4 allows, so that even the ports and flags of
+// the TCP header end up in different fragments.
+const fragmentSize = 8
+
+// ipFlags : Returns the flags of IPv4 packets, which have the Don't Fragment
+// bit set unless fragments are allowed.
+func (scanner *Scanner) ipFlags() layers.IPv4Flag {
+	if scanner.AllowFragments {
+		return 0
+	}
+
+	return layers.IPv4DontFragment
+}
+
+// sendFragments : Sends the TCP segment of a probe split into fragments of
+// fragmentSize bytes, every one of them with the IPv4 ID of the probe and
+// the More Fragments bit set but for the last.
+func (scanner *Scanner) sendFragments(ctx context.Context, eth *layers.Ethernet, ip4 *layers.IPv4, tcp *layers.TCP) error {
+	// The segment gets serialized on its own first, checksum included, which
+	// covers it as a whole.
+	segment := gopacket.NewSerializeBuffer()
+
+	if err := tcp.SerializeTo(segment, scanner.SerializeOptions); err != nil {
+		return err
+	}
+
+	data := segment.Bytes()
+
+	for offset := 0; offset < len(data); offset += fragmentSize {
+		end := offset + fragmentSize
+		fragment := *ip4
+		fragment.FragOffset = uint16(offset / 8)
+		fragment.Flags = 0
+
+		if end < len(data) {
+			fragment.Flags = layers.IPv4MoreFragments
+		} else {
+			end = len(data)
+		}
+
+		if err := scanner.SendPacket(ctx, eth, &fragment, gopacket.Payload(data[offset:end])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// readHTTP : Sends a HEAD request over conn and returns the status line of the
+// response, followed by the Server header if there is one.
+func readHTTP(conn net.Conn) (string, error) {
+	if _, err := fmt.Fprint(conn, "HEAD / HTTP/1.0\r\n\r\n"); err != nil {
+		return "", err
+	}
+
+	// The response is to a HEAD request, so it has no body to wait for,
+	// whatever length it gives.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodHead})
+
+	if err != nil {
+		return "", err
+	}
+
+	resp.Body.Close()
+
+	banner := fmt.Sprintf("%s %s", resp.Proto, resp.Status)
+
+	if server := resp.Header.Get("Server"); server != "" {
+		banner += ", " + server
+	}
+
+	return banner, nil
+}
@@ -0,0 +1,88 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPBanner(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("got a %s request", r.Method)
+		}
+
+		w.Header().Set("Server", "nginx/1.25.3")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, useTLS := range []bool{false, true} {
+		server := httptest.NewUnstartedServer(handler)
+
+		if useTLS {
+			server.StartTLS()
+		} else {
+			server.Start()
+		}
+
+		addr := server.Listener.Addr().(*net.TCPAddr)
+
+		scanner := newTestScanner(addr.IP, []uint16{uint16(addr.Port)}, fakeEthernet, nil)
+		scanner.HTTP = true
+		scanner.TLS = useTLS
+
+		banner, info, err := scanner.banner(context.Background(), uint16(addr.Port))
+		server.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "HTTP/1.0 418 I'm a teapot, nginx/1.25.3"; banner != want {
+			t.Errorf("tls %v: got %q, want %q", useTLS, banner, want)
+		}
+
+		// Over TLS, the handshake is still told about.
+		if (info != nil) != useTLS {
+			t.Errorf("tls %v: got %+v", useTLS, info)
+		}
+	}
+}
+
+// TestHTTPBannerLength checks that a response to the HEAD request giving the
+// length of a body it doesn't send isn't waited on until the BannerTimeout.
+func TestHTTPBannerLength(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	port := listen(t, "127.0.0.2", func(conn net.Conn) {
+		http.ReadRequest(bufio.NewReader(conn))
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 1000\r\nServer: nginx/1.25.3\r\n\r\n"))
+
+		// Like a server keeping the connection alive for the next request.
+		<-done
+	})
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{port}, fakeEthernet, nil)
+	scanner.HTTP = true
+	scanner.BannerTimeout = time.Second * 2
+
+	start := time.Now()
+	banner, _, err := scanner.banner(context.Background(), port)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "HTTP/1.1 200 OK, nginx/1.25.3"; banner != want {
+		t.Errorf("got %q, want %q", banner, want)
+	}
+
+	if elapsed := time.Since(start); elapsed > scanner.BannerTimeout / 4 {
+		t.Errorf("took %v, with a banner timeout of %v", elapsed, scanner.BannerTimeout)
+	}
+}
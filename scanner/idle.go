@@ -0,0 +1,76 @@
+package scanner
+
+import (
+	"sync"
+	"time"
+)
+
+// IdleDetector notices when the hosts being scanned have stopped answering
+// altogether, such as when a whole range is unreachable, and shortens the wait
+// for replies until one of them answers again. It's safe to share between
+// scanners, and a nil IdleDetector never shortens anything.
+type IdleDetector struct {
+	// How many hosts in a row have to stay quiet before the wait is shortened,
+	// and what it's shortened to.
+	After int
+	Timeout time.Duration
+
+	// How many hosts in a row stayed quiet so far.
+	quiet int
+	mutex sync.Mutex
+}
+
+// NewIdleDetector : Creates a detector that waits at most timeout for replies
+// once after hosts in a row didn't answer.
+func NewIdleDetector(after int, timeout time.Duration) *IdleDetector {
+	return &IdleDetector{After: after, Timeout: timeout}
+}
+
+// Observe : Takes the results of a scanned host into account. A single port
+// answering anything, even an ICMP error, means the range isn't dead.
+func (detector *IdleDetector) Observe(results []Result) {
+	if detector == nil {
+		return
+	}
+
+	answered := false
+
+	for _, result := range results {
+		if result.Reason != "" && result.Reason != "no-response" {
+			answered = true
+			break
+		}
+	}
+
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	if answered {
+		detector.quiet = 0
+	} else {
+		detector.quiet++
+	}
+}
+
+// Idle : Tells whether enough hosts in a row have stayed quiet to shorten the
+// wait for replies.
+func (detector *IdleDetector) Idle() bool {
+	if detector == nil {
+		return false
+	}
+
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	return detector.quiet >= detector.After
+}
+
+// shorten : Returns how long to wait for replies instead of wait, which is the
+// Timeout while idle, unless wait is shorter already.
+func (detector *IdleDetector) shorten(wait time.Duration) time.Duration {
+	if detector.Idle() && detector.Timeout < wait {
+		return detector.Timeout
+	}
+
+	return wait
+}
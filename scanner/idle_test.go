@@ -0,0 +1,54 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleDetector(t *testing.T) {
+	quiet := []Result{{Port: 22, Reason: "no-response"}, {Port: 2222, Reason: "no-response"}}
+	unreachable := []Result{{Port: 22, Reason: "no-response"}, {Port: 2222, Reason: "icmp-host-unreachable"}}
+
+	detector := NewIdleDetector(3, time.Millisecond * 100)
+
+	// It takes After quiet hosts in a row to go idle.
+	for i := 0; i < 3; i++ {
+		if detector.Idle() {
+			t.Fatalf("idle after %d quiet hosts, want 3", i)
+		}
+
+		detector.Observe(quiet)
+	}
+
+	if !detector.Idle() {
+		t.Fatal("not idle after 3 quiet hosts")
+	}
+
+	// Only waits longer than the Timeout are shortened.
+	if wait := detector.shorten(time.Second); wait != time.Millisecond * 100 {
+		t.Errorf("shortened 1s to %v, want 100ms", wait)
+	}
+
+	if wait := detector.shorten(time.Millisecond * 50); wait != time.Millisecond * 50 {
+		t.Errorf("shortened 50ms to %v, want it kept", wait)
+	}
+
+	// Any answer at all, even an ICMP error, starts the count over.
+	detector.Observe(unreachable)
+
+	if detector.Idle() {
+		t.Error("still idle after a host answered")
+	}
+
+	if wait := detector.shorten(time.Second); wait != time.Second {
+		t.Errorf("shortened 1s to %v after a host answered", wait)
+	}
+
+	// A nil detector never shortens anything.
+	var none *IdleDetector
+	none.Observe(quiet)
+
+	if none.Idle() || none.shorten(time.Second) != time.Second {
+		t.Error("a nil detector shortened the wait")
+	}
+}
@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// solicitedNode : Returns the solicited-node multicast group of an IPv6
+// address, along with the Ethernet multicast address the group maps to.
+func solicitedNode(ip net.IP) (net.IP, net.HardwareAddr) {
+	ip = ip.To16()
+
+	group := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xff, ip[13], ip[14], ip[15]}
+	mac := net.HardwareAddr{0x33, 0x33, group[12], group[13], group[14], group[15]}
+
+	return group, mac
+}
+
+// NeighborMACAddress : Gets the network address of an IPv6 neighbor, using
+// neighbor discovery the same way DestMACAddress uses ARP for IPv4.
+func (scanner *Scanner) NeighborMACAddress(ctx context.Context, ndpDst net.IP) (net.HardwareAddr, error) {
+	start := time.Now()
+	group, groupMAC := solicitedNode(ndpDst)
+
+	// Prepare the layers to SendPacket for a neighbor solicitation, which goes
+	// to the solicited-node group of the address we're looking for.
+	eth := layers.Ethernet{
+		SrcMAC: scanner.Interface.HardwareAddr,
+		DstMAC: groupMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+
+	ip6 := layers.IPv6{
+		SrcIP: scanner.SourceIP,
+		DstIP: group,
+		Version: 6,
+		HopLimit: 255,
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+
+	icmp := layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+
+	solicitation := layers.ICMPv6NeighborSolicitation{
+		TargetAddress: ndpDst,
+		Options: layers.ICMPv6Options{
+			{Type: layers.ICMPv6OptSourceAddress, Data: []byte(scanner.Interface.HardwareAddr)},
+		},
+	}
+
+	// Set the checksum of the network.
+	icmp.SetNetworkLayerForChecksum(&ip6)
+
+	nextSend := time.Time{}
+	sends := 0
+
+	// Wait for a neighbor advertisement and then return the address.
+	for {
+		// Send the neighbor solicitation, and send it again, backing off, if
+		// no advertisement shows up.
+		if sends <= scanner.Retries && !time.Now().Before(nextSend) {
+			if err := scanner.SendPacket(ctx, &eth, &ip6, &icmp, &solicitation); err != nil {
+				return nil, err
+			}
+
+			sends++
+			nextSend = time.Now().Add(scanner.backoff(sends))
+		}
+
+		// Has the scan been cancelled?
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Has time run out?
+		if time.Since(start) > timeout(scanner.ARPTimeout) {
+			return nil, fmt.Errorf("%w: no neighbor advertisement within %v", ErrNoNeighbor, timeout(scanner.ARPTimeout))
+		}
+
+		data, info, err := scanner.Handle.ReadPacketData()
+
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		packets := scanner.decoder()
+		packets.decode(data)
+
+		if !packets.has(layers.LayerTypeICMPv6NeighborAdvertisement) {
+			continue
+		}
+
+		advert := &packets.advert
+
+		if !advert.TargetAddress.Equal(ndpDst) {
+			continue
+		}
+
+		// Hosts on the link take as long to answer as probes, like with ARP.
+		if scanner.Gateway == nil {
+			scanner.observe(sends, since(start, info))
+		}
+
+		scanner.Metrics.received()
+
+		// The advertisement normally carries the address as an option...
+		for _, option := range advert.Options {
+			if option.Type == layers.ICMPv6OptTargetAddress && len(option.Data) == 6 {
+				return append(net.HardwareAddr(nil), option.Data...), nil
+			}
+		}
+
+		// ...but if it doesn't, it came from the neighbor itself.
+		return append(net.HardwareAddr(nil), packets.eth.SrcMAC...), nil
+	}
+}
@@ -0,0 +1,191 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// answerPorts6 : Returns an answer function for a fakeLink that answers
+// neighbor solicitations for any of the IPv6 hosts, and probes of their ports
+// going by what the port does, like answerPorts does for IPv4.
+func answerPorts6(t testing.TB, hosts map[string]map[uint16]string) func(data []byte, packets *decoder) [][]byte {
+	return func(data []byte, packets *decoder) [][]byte {
+		eth := packets.eth
+		ip6 := packets.ip6
+
+		if !packets.has(layers.LayerTypeIPv6) {
+			return nil
+		}
+
+		if packets.has(layers.LayerTypeICMPv6) && packets.icmp6.TypeCode.Type() == layers.ICMPv6TypeNeighborSolicitation {
+			target := solicitedTarget(packets)
+
+			if _, ok := hosts[target.String()]; !ok {
+				return nil
+			}
+
+			replyIP := &layers.IPv6{
+				SrcIP: target,
+				DstIP: ip6.SrcIP,
+				Version: 6,
+				HopLimit: 255,
+				NextHeader: layers.IPProtocolICMPv6,
+			}
+
+			icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)}
+			icmp.SetNetworkLayerForChecksum(replyIP)
+
+			return [][]byte{serialize(t,
+				&layers.Ethernet{SrcMAC: fakeHostMAC, DstMAC: eth.SrcMAC, EthernetType: layers.EthernetTypeIPv6},
+				replyIP,
+				icmp,
+				&layers.ICMPv6NeighborAdvertisement{
+					Flags: 0x60,
+					TargetAddress: target,
+					Options: layers.ICMPv6Options{{Type: layers.ICMPv6OptTargetAddress, Data: fakeHostMAC}},
+				})}
+		}
+
+		if !packets.has(layers.LayerTypeTCP) {
+			return nil
+		}
+
+		probe := packets.tcp
+
+		reply := func(flags func(tcp *layers.TCP)) [][]byte {
+			replyIP := &layers.IPv6{
+				SrcIP: ip6.DstIP,
+				DstIP: ip6.SrcIP,
+				Version: 6,
+				HopLimit: 64,
+				NextHeader: layers.IPProtocolTCP,
+			}
+
+			tcp := replySegment(&probe, flags)
+			tcp.SetNetworkLayerForChecksum(replyIP)
+
+			return [][]byte{serialize(t, &layers.Ethernet{SrcMAC: eth.DstMAC, DstMAC: eth.SrcMAC, EthernetType: layers.EthernetTypeIPv6}, replyIP, tcp)}
+		}
+
+		switch hosts[ip6.DstIP.String()][uint16(probe.DstPort)] {
+		case portOpen:
+			return reply(func(tcp *layers.TCP) {
+				tcp.SYN = true
+				tcp.ACK = true
+			})
+		case portClosed:
+			return reply(func(tcp *layers.TCP) {
+				tcp.RST = true
+				tcp.ACK = true
+			})
+		}
+
+		return nil
+	}
+}
+
+// solicitedTarget : Returns the address a neighbor solicitation asks about,
+// which comes after the reserved bytes of its body. The decoder of the scanners
+// has no use for solicitations, so it leaves the body undecoded.
+func solicitedTarget(packets *decoder) net.IP {
+	if len(packets.icmp6.Payload) < 20 {
+		return nil
+	}
+
+	return net.IP(packets.icmp6.Payload[4:20])
+}
+
+// TestScanIPv6 checks that IPv6 hosts are found through neighbor discovery,
+// get IPv6 probes, and have their replies matched to them.
+func TestScanIPv6(t *testing.T) {
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	} else {
+		listener.Close()
+	}
+
+	open := listenSSH(t, "::1")
+	ip := net.ParseIP("::1")
+
+	link := newFakeLink(answerPorts6(t, map[string]map[uint16]string{"::1": {open: portOpen, 2201: portClosed}}))
+	defer link.Close()
+
+	scanner := newTestScanner(ip, []uint16{open, 2201, 2202}, fakeEthernet, link)
+	scanner.DestIP = ip
+	scanner.SourceIP = ip
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint16]PortState{open: Open, 2201: Closed, 2202: Filtered}
+
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+
+	for _, result := range results {
+		if result.State != want[result.Port] || result.IP != "::1" {
+			t.Errorf("port %d: got %v (%s) for %s, want %v", result.Port, result.State, result.Reason, result.IP, want[result.Port])
+		}
+
+		if result.Port == open && result.Software != "OpenSSH_9.6p1" {
+			t.Errorf("open port: got software %q, banner %q", result.Software, result.Banner)
+		}
+	}
+
+	// The host was looked for through its solicited-node group, with no ARP.
+	if arps := link.sent(layers.LayerTypeARP); len(arps) != 0 {
+		t.Errorf("got %d ARP requests sent", len(arps))
+	}
+
+	solicitations := link.sent(layers.LayerTypeICMPv6)
+
+	if len(solicitations) == 0 {
+		t.Fatal("no neighbor solicitation sent")
+	}
+
+	group := net.ParseIP("ff02::1:ff00:1")
+
+	for _, sent := range solicitations {
+		if sent.icmp6.TypeCode.Type() != layers.ICMPv6TypeNeighborSolicitation {
+			t.Errorf("got ICMPv6 %v sent, want a neighbor solicitation", sent.icmp6.TypeCode)
+		}
+
+		if !bytes.Equal(sent.eth.DstMAC, net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 0x01}) || !sent.ip6.DstIP.Equal(group) || sent.ip6.HopLimit != 255 {
+			t.Errorf("solicitation sent to %v (%v), hop limit %d", sent.ip6.DstIP, sent.eth.DstMAC, sent.ip6.HopLimit)
+		}
+
+		if target := solicitedTarget(sent); !target.Equal(ip) {
+			t.Errorf("solicitation asked about %v, want %v", target, ip)
+		}
+	}
+
+	// The probes are IPv6 ones, sent to the address the host advertised.
+	probes := link.sent(layers.LayerTypeTCP)
+
+	if len(probes) == 0 {
+		t.Fatal("no probes sent")
+	}
+
+	for _, probe := range probes {
+		if probe.eth.EthernetType != layers.EthernetTypeIPv6 || !probe.has(layers.LayerTypeIPv6) {
+			t.Errorf("probe sent as %v, want IPv6", probe.eth.EthernetType)
+			continue
+		}
+
+		if !probe.ip6.SrcIP.Equal(ip) || !probe.ip6.DstIP.Equal(ip) || probe.ip6.NextHeader != layers.IPProtocolTCP || probe.ip6.HopLimit != DefaultTTL {
+			t.Errorf("probe sent from %v to %v, next header %v, hop limit %d", probe.ip6.SrcIP, probe.ip6.DstIP, probe.ip6.NextHeader, probe.ip6.HopLimit)
+		}
+
+		if !bytes.Equal(probe.eth.DstMAC, fakeHostMAC) || !probe.tcp.SYN {
+			t.Errorf("probe sent to %v, SYN %v", probe.eth.DstMAC, probe.tcp.SYN)
+		}
+	}
+}
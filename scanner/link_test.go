@@ -0,0 +1,381 @@
+package scanner
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// fakeLink is a PacketIO standing in for the network behind a PCAP handle.
+// Every packet written to it is handed to answer, and the replies it returns
+// come back out of ReadPacketData, stamped with when they were "captured",
+// which is when they're injected.
+type fakeLink struct {
+	answer func(data []byte, packets *decoder) [][]byte
+
+	// How long replies take to come back, if they take any time at all.
+	delay time.Duration
+
+	replies chan capturedPacket
+	closed chan struct{}
+	closeOnce sync.Once
+
+	// How many packets were written, each of them and when, and the last BPF
+	// filter set.
+	written int
+	frames [][]byte
+	times []time.Time
+	filter string
+	mutex sync.Mutex
+}
+
+// newFakeLink : Creates a link answering packets with the given function.
+func newFakeLink(answer func(data []byte, packets *decoder) [][]byte) *fakeLink {
+	return &fakeLink{
+		answer: answer,
+		replies: make(chan capturedPacket, 1024),
+		closed: make(chan struct{}),
+	}
+}
+
+// ReadPacketData : Returns the next reply, or pcap.NextErrorTimeoutExpired if
+// there's none for a little while.
+func (link *fakeLink) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	select {
+	case packet := <-link.replies:
+		return packet.data, packet.info, nil
+	case <-link.closed:
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	case <-time.After(time.Millisecond * 5):
+		return nil, gopacket.CaptureInfo{}, pcap.NextErrorTimeoutExpired
+	}
+}
+
+// WritePacketData : Answers a packet.
+func (link *fakeLink) WritePacketData(data []byte) error {
+	// The scanner reuses its buffer, and the decoder of the scanner isn't
+	// ours to use.
+	data = append([]byte(nil), data...)
+
+	link.mutex.Lock()
+	link.written++
+	link.frames = append(link.frames, data)
+	link.times = append(link.times, time.Now())
+	link.mutex.Unlock()
+
+	if link.answer == nil {
+		return nil
+	}
+
+	packets := newDecoder()
+	packets.decode(data)
+
+	for _, reply := range link.answer(data, packets) {
+		if link.delay > 0 {
+			time.AfterFunc(link.delay, func() {
+				link.inject(reply)
+			})
+		} else {
+			link.inject(reply)
+		}
+	}
+
+	return nil
+}
+
+// sent : Returns every packet written so far that has a layer of the given
+// type, decoded, in the order they were written.
+func (link *fakeLink) sent(layerType gopacket.LayerType) []*decoder {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+
+	packets := []*decoder{}
+
+	for _, frame := range link.frames {
+		d := newDecoder()
+		d.decode(frame)
+
+		if d.has(layerType) {
+			packets = append(packets, d)
+		}
+	}
+
+	return packets
+}
+
+// inject : Has a packet show up on the link as if it had been captured.
+func (link *fakeLink) inject(data []byte) {
+	link.replies <- capturedPacket{data, gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}}
+}
+
+// SetBPFFilter : Only records the filter, the link carries what it's told to.
+func (link *fakeLink) SetBPFFilter(filter string) error {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+
+	link.filter = filter
+
+	return nil
+}
+
+// Close : Has reads fail from now on, like on a closed PCAP handle.
+func (link *fakeLink) Close() {
+	link.closeOnce.Do(func() {
+		close(link.closed)
+	})
+}
+
+// failingLink is a fakeLink that fails to send the first few packets with a
+// layer of the given type written to it with the given error, and sends the
+// rest.
+type failingLink struct {
+	*fakeLink
+	layerType gopacket.LayerType
+	err error
+	failures int
+
+	// How many times packets of the type were written, failing or not.
+	attempts int
+}
+
+// WritePacketData : Fails while there are failures left, and answers the
+// packet once there are none.
+func (link *failingLink) WritePacketData(data []byte) error {
+	packets := newDecoder()
+	packets.decode(data)
+
+	if packets.has(link.layerType) {
+		link.attempts++
+
+		if link.failures > 0 {
+			link.failures--
+			return link.err
+		}
+	}
+
+	return link.fakeLink.WritePacketData(data)
+}
+
+// The ways the ports of fake hosts answer probes.
+const (
+	portOpen = "open"
+	portClosed = "closed"
+	portProhibited = "prohibited"
+	portSilent = "silent"
+)
+
+// fakeHostMAC is the network address every fake host answers ARP with.
+var fakeHostMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+
+// answerPorts : Returns an answer function for a fakeLink that answers ARP
+// requests for any of the hosts, and probes of their ports going by what the
+// port does. Ports the hosts don't list stay silent.
+func answerPorts(t testing.TB, hosts map[string]map[uint16]string) func(data []byte, packets *decoder) [][]byte {
+	return func(data []byte, packets *decoder) [][]byte {
+		eth := packets.eth
+
+		if packets.has(layers.LayerTypeARP) {
+			arp := packets.arp
+			target := net.IP(arp.DstProtAddress).String()
+
+			if _, ok := hosts[target]; !ok || arp.Operation != layers.ARPRequest {
+				return nil
+			}
+
+			return [][]byte{serialize(t,
+				&layers.Ethernet{SrcMAC: fakeHostMAC, DstMAC: eth.SrcMAC, EthernetType: layers.EthernetTypeARP},
+				&layers.ARP{
+					AddrType: layers.LinkTypeEthernet,
+					Protocol: layers.EthernetTypeIPv4,
+					HwAddressSize: 6,
+					ProtAddressSize: 4,
+					Operation: layers.ARPReply,
+					SourceHwAddress: fakeHostMAC,
+					SourceProtAddress: arp.DstProtAddress,
+					DstHwAddress: arp.SourceHwAddress,
+					DstProtAddress: arp.SourceProtAddress,
+				})}
+		}
+
+		if !packets.has(layers.LayerTypeTCP) || !packets.has(layers.LayerTypeIPv4) {
+			return nil
+		}
+
+		probe := packets.tcp
+		ip4 := packets.ip4
+
+		switch hosts[ip4.DstIP.String()][uint16(probe.DstPort)] {
+		case portOpen:
+			return [][]byte{tcpReply(t, &eth, &ip4, &probe, func(tcp *layers.TCP) {
+				tcp.SYN = true
+				tcp.ACK = true
+			})}
+		case portClosed:
+			return [][]byte{tcpReply(t, &eth, &ip4, &probe, func(tcp *layers.TCP) {
+				tcp.RST = true
+				tcp.ACK = true
+			})}
+		case portProhibited:
+			return [][]byte{unreachableReply(t, &eth, &ip4, data[14:], layers.ICMPv4CodeCommAdminProhibited)}
+		}
+
+		return nil
+	}
+}
+
+// tcpReply : Builds the reply of a host to a probe, with the flags set by the
+// given function.
+func tcpReply(t testing.TB, eth *layers.Ethernet, ip4 *layers.IPv4, probe *layers.TCP, flags func(tcp *layers.TCP)) []byte {
+	replyIP := &layers.IPv4{
+		SrcIP: ip4.DstIP,
+		DstIP: ip4.SrcIP,
+		Version: 4,
+		TTL: 64,
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	tcp := replySegment(probe, flags)
+	tcp.SetNetworkLayerForChecksum(replyIP)
+
+	return serialize(t, &layers.Ethernet{SrcMAC: eth.DstMAC, DstMAC: eth.SrcMAC, EthernetType: layers.EthernetTypeIPv4}, replyIP, tcp)
+}
+
+// replySegment : Builds the TCP segment of the reply to a probe, with the flags
+// set by the given function, acknowledging the probe like an actual TCP stack
+// would, which counts the SYN and FIN flags as a byte each.
+func replySegment(probe *layers.TCP, flags func(tcp *layers.TCP)) *layers.TCP {
+	tcp := &layers.TCP{
+		SrcPort: probe.DstPort,
+		DstPort: probe.SrcPort,
+		Seq: 1000,
+		Ack: probe.Seq + uint32(len(probe.Payload)),
+		Window: 64240,
+	}
+
+	if probe.SYN {
+		tcp.Ack++
+	}
+
+	if probe.FIN {
+		tcp.Ack++
+	}
+
+	flags(tcp)
+
+	return tcp
+}
+
+// unreachableReply : Builds the ICMP destination unreachable error a router
+// answers a probe with, quoting the IPv4 header and TCP ports of the probe.
+func unreachableReply(t testing.TB, eth *layers.Ethernet, ip4 *layers.IPv4, quoted []byte, code uint8) []byte {
+	router := net.IP{10, 255, 255, 254}
+
+	return serialize(t,
+		&layers.Ethernet{SrcMAC: eth.DstMAC, DstMAC: eth.SrcMAC, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{SrcIP: router, DstIP: ip4.SrcIP, Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, code)},
+		gopacket.Payload(quoted[:28]))
+}
+
+// serialize : Turns layers into a packet.
+func serialize(t testing.TB, l ...gopacket.SerializableLayer) []byte {
+	buffer := gopacket.NewSerializeBuffer()
+
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, l...); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// newTestScanner : Creates a scanner for a host behind the given handle, on the
+// given interface, that doesn't wait long for replies. The fake hosts live on
+// the loopback network, so that banners can be grabbed from listeners of
+// their open ports while the probes go through the handle.
+func newTestScanner(ip net.IP, ports []uint16, iface *net.Interface, handle PacketIO) *Scanner {
+	return &Scanner{
+		DestIP: ip.To4(),
+		DestPorts: ports,
+		SrcPort: RandomSourcePort(),
+		SourceIP: net.IP{127, 0, 0, 1},
+		Interface: iface,
+		ScanTimeout: time.Millisecond * 300,
+		Handle: handle,
+		Buffer: gopacket.NewSerializeBuffer(),
+		SerializeOptions: gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+	}
+}
+
+// fakeEthernet is the interface of the scanners behind a fake link. It isn't
+// a loopback interface, so the fake hosts get resolved through ARP.
+var fakeEthernet = &net.Interface{
+	Name: "fake0",
+	MTU: 1500,
+	Flags: net.FlagUp | net.FlagBroadcast,
+	HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01},
+}
+
+// listenSSH : Starts an SSH server on an address of the fake host, sending just
+// its identification string, and returns its port.
+func listenSSH(t testing.TB, ip string) uint16 {
+	return listenSlowSSH(t, ip, 0)
+}
+
+// listenSlowSSH : Starts an SSH server like listenSSH does, which waits for a
+// while before sending its identification string.
+func listenSlowSSH(t testing.TB, ip string, wait time.Duration) uint16 {
+	return listen(t, ip, func(conn net.Conn) {
+		time.Sleep(wait)
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n"))
+	})
+}
+
+// listen : Starts a server on an address of the fake host, which serves every
+// connection with the given function and closes it, and returns its port.
+func listen(t testing.TB, ip string, serve func(conn net.Conn)) uint16 {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// fakeRouter routes every host through fakeEthernet, without a gateway.
+type fakeRouter struct{}
+
+// Route : Routes a host through fakeEthernet.
+func (router fakeRouter) Route(dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return fakeEthernet, nil, net.IP{127, 0, 0, 1}, nil
+}
+
+// RouteWithSrc : Routes a host through fakeEthernet, whatever the source.
+func (router fakeRouter) RouteWithSrc(input net.HardwareAddr, src, dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return router.Route(dst)
+}
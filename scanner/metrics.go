@@ -0,0 +1,121 @@
+package scanner
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hostDurationBuckets are the upper bounds, in seconds, of the buckets of the
+// host scan duration histogram.
+var hostDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics counts what the scanners sharing it did, and serves the counts to
+// Prometheus over HTTP in its text format. It's safe to share between
+// scanners, and a nil Metrics counts nothing.
+type Metrics struct {
+	packetsSent uint64
+	repliesReceived uint64
+
+	// How many ports ended up in each state.
+	ports map[PortState]uint64
+
+	// How long hosts took to scan, per bucket, along with the total.
+	durations []uint64
+	durationSum float64
+	durationCount uint64
+
+	mutex sync.Mutex
+}
+
+// NewMetrics : Creates a set of metrics with everything at zero.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ports: make(map[PortState]uint64),
+		durations: make([]uint64, len(hostDurationBuckets)),
+	}
+}
+
+// sent : Counts a packet that was sent.
+func (metrics *Metrics) sent() {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.packetsSent++
+}
+
+// received : Counts a reply to something that was sent.
+func (metrics *Metrics) received() {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.repliesReceived++
+}
+
+// scanned : Counts the results of a host that took the given time to scan.
+func (metrics *Metrics) scanned(results []Result, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	for _, result := range results {
+		metrics.ports[result.State]++
+	}
+
+	seconds := duration.Seconds()
+
+	for i, bound := range hostDurationBuckets {
+		if seconds <= bound {
+			metrics.durations[i]++
+		}
+	}
+
+	metrics.durationSum += seconds
+	metrics.durationCount++
+}
+
+// ServeHTTP : Writes out the metrics in the Prometheus text format.
+func (metrics *Metrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(writer, "# HELP shellscan_packets_sent_total Packets sent, ARP and neighbor discovery included.\n")
+	fmt.Fprintf(writer, "# TYPE shellscan_packets_sent_total counter\n")
+	fmt.Fprintf(writer, "shellscan_packets_sent_total %d\n", metrics.packetsSent)
+
+	fmt.Fprintf(writer, "# HELP shellscan_replies_received_total Replies received to the packets sent.\n")
+	fmt.Fprintf(writer, "# TYPE shellscan_replies_received_total counter\n")
+	fmt.Fprintf(writer, "shellscan_replies_received_total %d\n", metrics.repliesReceived)
+
+	fmt.Fprintf(writer, "# HELP shellscan_ports_total Ports scanned, by the state they were found in.\n")
+	fmt.Fprintf(writer, "# TYPE shellscan_ports_total counter\n")
+
+	for _, state := range []PortState{Open, Closed, Filtered, OpenFiltered, DialFailed} {
+		fmt.Fprintf(writer, "shellscan_ports_total{state=%q} %d\n", state, metrics.ports[state])
+	}
+
+	fmt.Fprintf(writer, "# HELP shellscan_host_duration_seconds Time taken to scan a host.\n")
+	fmt.Fprintf(writer, "# TYPE shellscan_host_duration_seconds histogram\n")
+
+	for i, bound := range hostDurationBuckets {
+		fmt.Fprintf(writer, "shellscan_host_duration_seconds_bucket{le=\"%g\"} %d\n", bound, metrics.durations[i])
+	}
+
+	fmt.Fprintf(writer, "shellscan_host_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.durationCount)
+	fmt.Fprintf(writer, "shellscan_host_duration_seconds_sum %g\n", metrics.durationSum)
+	fmt.Fprintf(writer, "shellscan_host_duration_seconds_count %d\n", metrics.durationCount)
+}
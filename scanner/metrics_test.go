@@ -0,0 +1,71 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {
+		open: portOpen,
+		2201: portClosed,
+		2202: portProhibited,
+		2203: portSilent,
+	}}))
+
+	defer link.Close()
+
+	metrics := NewMetrics()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open, 2201, 2202, 2203}, fakeEthernet, link)
+	scanner.Metrics = metrics
+
+	if _, err := scanner.Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL + "/metrics")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("got content type %q", contentType)
+	}
+
+	// The ARP request and a probe to every port went out, and everything but
+	// the silent port answered, the ICMP error included.
+	for _, line := range []string{
+		"shellscan_packets_sent_total 5",
+		"shellscan_replies_received_total 4",
+		`shellscan_ports_total{state="open"} 1`,
+		`shellscan_ports_total{state="closed"} 1`,
+		`shellscan_ports_total{state="filtered"} 2`,
+		`shellscan_ports_total{state="open|filtered"} 0`,
+		`shellscan_ports_total{state="dial-failed"} 0`,
+		`shellscan_host_duration_seconds_bucket{le="+Inf"} 1`,
+		"shellscan_host_duration_seconds_count 1",
+	} {
+		if !strings.Contains(string(body), line + "\n") {
+			t.Errorf("no %q in\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package scanner
+
+import (
+	"sync"
+)
+
+// snapLen is how much of every packet PCAP captures.
+const snapLen = 65536
+
+// The smallest MTUs IPv4 and IPv6 hosts have to be able to take, below which
+// replies may not make it through whole, and the MTU of plain Ethernet, above
+// which the interface uses jumbo frames.
+const (
+	minMTU4 = 576
+	minMTU6 = 1280
+	maxMTU = 1500
+)
+
+// warnedMTU holds the names of the interfaces whose MTU was warned about
+// already, so that it's only done once per interface and not once per host.
+var warnedMTU sync.Map
+
+// checkMTU : Warns about an MTU of the interface that replies may not fit in,
+// or jumbo frames, which fill up the capture buffer (sized in frames of plain
+// Ethernet by libpcap's default) that much faster, and which may not even fit
+// the capture length.
+func (scanner *Scanner) checkMTU() {
+	min := minMTU4
+
+	if scanner.DestIP.To4() == nil {
+		min = minMTU6
+	}
+
+	mtu := scanner.MTU
+
+	// Loopback and tunnel interfaces may not report an MTU at all.
+	if mtu <= 0 || (mtu >= min && mtu <= maxMTU) {
+		return
+	}
+
+	if _, warned := warnedMTU.LoadOrStore(scanner.Interface.Name, struct{}{}); warned {
+		return
+	}
+
+	switch {
+	case mtu < min:
+		scanner.logger().Warn("Interface MTU is unusually small, replies may be cut short", "interface", scanner.Interface.Name, "mtu", mtu)
+	case mtu > snapLen:
+		scanner.logger().Warn("Interface MTU is larger than the capture length, jumbo frames will be cut short", "interface", scanner.Interface.Name, "mtu", mtu, "snaplen", snapLen)
+	default:
+		scanner.logger().Warn("Interface uses jumbo frames, which fill up the capture buffer faster, replies may be dropped unless it's larger", "interface", scanner.Interface.Name, "mtu", mtu)
+	}
+}
@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCheckMTU(t *testing.T) {
+	for i, c := range []struct {
+		ip net.IP
+		mtu int
+		warning string
+	}{
+		{net.IP{10, 0, 0, 2}, 1500, ""},
+		{net.IP{10, 0, 0, 2}, 0, ""},
+		{net.IP{10, 0, 0, 2}, 576, ""},
+		{net.IP{10, 0, 0, 2}, 500, "unusually small"},
+		{net.ParseIP("fd00::2"), 1000, "unusually small"},
+		{net.IP{10, 0, 0, 2}, 9000, "jumbo frames"},
+		{net.IP{10, 0, 0, 2}, 65536 * 2, "larger than the capture length"},
+	} {
+		logs := &bytes.Buffer{}
+
+		// Every case gets an interface of its own, since every interface is
+		// only warned about once.
+		name := fmt.Sprintf("mtu%d", i)
+
+		// Nor is it warned about already by an earlier run of the test.
+		warnedMTU.Delete(name)
+
+		scanner := &Scanner{
+			DestIP: c.ip,
+			MTU: c.mtu,
+			Interface: &net.Interface{Name: name, MTU: c.mtu},
+			Logger: slog.New(slog.NewTextHandler(logs, nil)),
+		}
+
+		scanner.checkMTU()
+
+		if c.warning == "" && logs.Len() > 0 || !strings.Contains(logs.String(), c.warning) {
+			t.Errorf("MTU %d: got %q, want %q", c.mtu, logs.String(), c.warning)
+		}
+
+		// And only once.
+		logs.Reset()
+		scanner.checkMTU()
+
+		if logs.Len() > 0 {
+			t.Errorf("MTU %d: warned again", c.mtu)
+		}
+	}
+}
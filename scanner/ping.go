@@ -0,0 +1,128 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// ErrHostDown is returned by Scan when the host didn't answer the ping sent
+// before the probes, with Options.Ping set.
+var ErrHostDown = errors.New("Host didn't answer the ping")
+
+// alive : Tells whether the host is up. Hosts on the link are, since they
+// answered ARP (or neighbor discovery) already, which they can't ignore the
+// way they can ignore pings. Everything else has to answer an ICMP (or ICMPv6)
+// echo request, which is sent again like the probes when it gets no reply.
+func (scanner *Scanner) alive(ctx context.Context, eth *layers.Ethernet) (bool, error) {
+	if scanner.Gateway == nil && scanner.Interface.Flags & net.FlagLoopback == 0 {
+		return true, nil
+	}
+
+	id := uint16(rand.Intn(65536))
+	start := time.Now()
+	lastSent := time.Time{}
+	sends := 0
+
+	for {
+		if scanner.retransmit(sends, lastSent) {
+			if err := scanner.sendPing(ctx, eth, id, uint16(sends)); err != nil {
+				return false, err
+			}
+
+			lastSent = time.Now()
+			sends++
+		}
+
+		// Has the scan been cancelled, or has the host run out of time?
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		// Hosts get as long to answer the ping as they get for the probes.
+		if time.Since(start) > scanner.scanTimeout() {
+			return false, nil
+		}
+
+		data, info, err := scanner.Handle.ReadPacketData()
+
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			scanner.logger().Warn("Error reading packet", "ip", scanner.DestIP, "err", err)
+			continue
+		}
+
+		packets := scanner.decoder()
+		packets.decode(data)
+
+		if packets.echoReply(scanner.DestIP, id) {
+			scanner.Metrics.received()
+			scanner.observe(sends, since(lastSent, info))
+
+			return true, nil
+		}
+	}
+}
+
+// linkBroadcast : Returns the address that reaches every host on the link,
+// which is the Ethernet broadcast address for IPv4 and the all-nodes multicast
+// group for IPv6.
+func linkBroadcast(ip net.IP) net.HardwareAddr {
+	if ip.To4() != nil {
+		return net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+
+	return net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0x01}
+}
+
+// sendPing : Sends the host an ICMP (or ICMPv6) echo request with the given ID
+// and sequence number.
+func (scanner *Scanner) sendPing(ctx context.Context, eth *layers.Ethernet, id uint16, seq uint16) error {
+	if scanner.DestIP.To4() != nil {
+		ip4 := layers.IPv4{
+			SrcIP: scanner.SourceIP,
+			DstIP: scanner.DestIP,
+			Version: 4,
+			TTL: scanner.ttl(),
+			Id: uint16(rand.Intn(65536)),
+			Flags: scanner.ipFlags(),
+			Protocol: layers.IPProtocolICMPv4,
+		}
+
+		icmp := layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+			Id: id,
+			Seq: seq,
+		}
+
+		return scanner.SendPacket(ctx, eth, &ip4, &icmp)
+	}
+
+	ip6 := layers.IPv6{
+		SrcIP: scanner.SourceIP,
+		DstIP: scanner.DestIP,
+		Version: 6,
+		HopLimit: scanner.ttl(),
+		NextHeader: layers.IPProtocolICMPv6,
+	}
+
+	icmp := layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoRequest, 0),
+	}
+
+	echo := layers.ICMPv6Echo{
+		Identifier: id,
+		SeqNumber: seq,
+	}
+
+	// Set the checksum of the network.
+	icmp.SetNetworkLayerForChecksum(&ip6)
+
+	return scanner.SendPacket(ctx, eth, &ip6, &icmp, &echo)
+}
@@ -0,0 +1,442 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// DefaultReadTimeout is how long a PCAP read may block unless told otherwise,
+// which bounds how quickly a scan notices that it has been cancelled or has
+// timed out.
+const DefaultReadTimeout = time.Millisecond * 100
+
+// Pool shares a single PCAP handle between all the scanners sending
+// packets out of the same interface, instead of opening one per target.
+type Pool struct {
+	// How long a read of the handles may block, and how big their capture
+	// buffers are. Zero means DefaultReadTimeout and libpcap's default buffer
+	// size. They have to be set before the pool is used.
+	ReadTimeout time.Duration
+	BufferSize int
+
+	// The shared handles, keyed on interface name.
+	handles map[string]*sharedHandle
+	mutex sync.Mutex
+
+	// Opens the handle of an interface, which is a PCAP handle unless tests
+	// say otherwise.
+	open func(iface string) (captureHandle, error)
+}
+
+// captureHandle is what a pool shares between scanners, which is normally a
+// PCAP handle.
+type captureHandle interface {
+	PacketIO
+	SetBPFFilter(filter string) error
+	Close()
+}
+
+var _ captureHandle = (*liveHandle)(nil)
+
+// sharedHandle is a PCAP handle along with the scanners listening on it.
+type sharedHandle struct {
+	handle captureHandle
+
+	// How long reads of the scanners wait for a packet.
+	timeout time.Duration
+
+	// Writes from the different scanners are serialized.
+	writeMutex sync.Mutex
+
+	// The handles of the scanners that want packets coming from an address,
+	// keyed on that address.
+	subscribers map[string][]*PoolHandle
+	mutex sync.Mutex
+
+	// The source addresses the scanners send from, which the BPF filter lets
+	// replies through for.
+	sources []net.IP
+}
+
+// PoolHandle is the view a single scanner has of a shared PCAP handle. It only
+// receives the packets coming from the addresses the scanner cares about.
+type PoolHandle struct {
+	shared *sharedHandle
+	addresses []string
+	packets chan capturedPacket
+}
+
+// capturedPacket is a packet along with when and how it was captured.
+type capturedPacket struct {
+	data []byte
+	info gopacket.CaptureInfo
+}
+
+// NewPool : Creates an empty pool.
+func NewPool() *Pool {
+	return &Pool{
+		handles: make(map[string]*sharedHandle),
+	}
+}
+
+// Open : Returns a handle on the given interface, for a scanner sending from
+// the source address, that receives every packet sent by one of the given
+// addresses. The PCAP handle of the interface is opened the first time it's
+// needed.
+func (pool *Pool) Open(iface string, source net.IP, addresses ...net.IP) (*PoolHandle, error) {
+	pool.mutex.Lock()
+	shared, err := pool.shared(iface)
+
+	if err != nil {
+		pool.mutex.Unlock()
+		return nil, err
+	}
+
+	// Make sure the kernel lets through the replies to this source address.
+	if err := shared.allow(source); err != nil {
+		pool.mutex.Unlock()
+		return nil, err
+	}
+
+	pool.mutex.Unlock()
+
+	poolHandle := &PoolHandle{
+		shared: shared,
+		packets: make(chan capturedPacket, 64),
+	}
+
+	shared.mutex.Lock()
+
+	for _, address := range addresses {
+		if address == nil {
+			continue
+		}
+
+		key := address.String()
+		poolHandle.addresses = append(poolHandle.addresses, key)
+		shared.subscribers[key] = append(shared.subscribers[key], poolHandle)
+	}
+
+	shared.mutex.Unlock()
+
+	return poolHandle, nil
+}
+
+// Prepare : Opens the PCAP handle of an interface ahead of time, which is a
+// cheap way of finding out whether we're allowed to capture packets at all
+// before scanning anything.
+func (pool *Pool) Prepare(iface string) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	_, err := pool.shared(iface)
+
+	return err
+}
+
+// shared : Returns the shared handle of an interface, opening it if needed.
+// The pool must be locked.
+func (pool *Pool) shared(iface string) (*sharedHandle, error) {
+	if shared, ok := pool.handles[iface]; ok {
+		return shared, nil
+	}
+
+	open := pool.open
+
+	if open == nil {
+		open = func(iface string) (captureHandle, error) {
+			return openLive(iface, pool.ReadTimeout, pool.BufferSize)
+		}
+	}
+
+	handle, err := open(iface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shared := &sharedHandle{
+		handle: handle,
+		timeout: pool.ReadTimeout,
+		subscribers: make(map[string][]*PoolHandle),
+	}
+
+	if shared.timeout <= 0 {
+		shared.timeout = DefaultReadTimeout
+	}
+
+	pool.handles[iface] = shared
+
+	go shared.demultiplex()
+
+	return shared, nil
+}
+
+// ErrPermission is returned when we aren't allowed to capture and send raw
+// packets.
+var ErrPermission = errors.New("Not allowed to capture packets, run with sudo or grant the CAP_NET_RAW capability (setcap cap_net_raw+ep shellscan)")
+
+// liveHandle is a PCAP handle whose reads block for the whole read timeout
+// when no packet shows up.
+type liveHandle struct {
+	*pcap.Handle
+	timeout time.Duration
+}
+
+// openLive : Opens a PCAP handle on an interface, under the name PCAP knows it
+// by, for editing ops, telling permission problems apart from the rest since
+// libpcap's own message is rather cryptic. Reads block for at most the timeout,
+// or DefaultReadTimeout if it's zero, but never forever, so that cancellation
+// gets noticed. The capture buffer is bufferSize bytes, unless it's zero.
+func openLive(iface string, timeout time.Duration, bufferSize int) (*liveHandle, error) {
+	if timeout <= 0 {
+		timeout = DefaultReadTimeout
+	}
+
+	device, err := deviceName(iface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pcapHandle *pcap.Handle
+
+	if bufferSize > 0 {
+		pcapHandle, err = openBuffered(device, timeout, bufferSize)
+	} else {
+		pcapHandle, err = pcap.OpenLive(device, snapLen, true, timeout)
+	}
+
+	if err != nil && isPermissionError(err) {
+		return nil, fmt.Errorf("%w: %v", ErrPermission, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &liveHandle{Handle: pcapHandle, timeout: timeout}, nil
+}
+
+// openBuffered : Opens a PCAP handle like pcap.OpenLive does, but with a
+// capture buffer of the given size, so that replies aren't dropped when they
+// come in faster than they're read.
+func openBuffered(iface string, timeout time.Duration, bufferSize int) (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle(iface)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer inactive.CleanUp()
+
+	if err := configureHandle(inactive, timeout, bufferSize); err != nil {
+		return nil, err
+	}
+
+	return inactive.Activate()
+}
+
+// inactiveHandle is what gets set on a PCAP handle before it's activated,
+// which is a pcap.InactiveHandle unless tests say otherwise.
+type inactiveHandle interface {
+	SetSnapLen(snaplen int) error
+	SetPromisc(promisc bool) error
+	SetTimeout(timeout time.Duration) error
+	SetBufferSize(bufferSize int) error
+}
+
+// configureHandle : Sets up an inactive PCAP handle the way pcap.OpenLive sets
+// up its handles, with a capture buffer of the given size.
+func configureHandle(inactive inactiveHandle, timeout time.Duration, bufferSize int) error {
+	if err := inactive.SetSnapLen(snapLen); err != nil {
+		return err
+	}
+
+	if err := inactive.SetPromisc(true); err != nil {
+		return err
+	}
+
+	if err := inactive.SetTimeout(timeout); err != nil {
+		return err
+	}
+
+	return inactive.SetBufferSize(bufferSize)
+}
+
+// ReadPacketData : Returns the next packet, or pcap.NextErrorTimeoutExpired if
+// none arrived within the timeout.
+func (handle *liveHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return readWithin(handle.Handle, handle.timeout)
+}
+
+// readWithin : Reads the next packet off handle, whose reads give up after the
+// timeout. Some libpcap builds give up on reads right away instead of waiting
+// out the timeout, which would have the read loops spin and eat a whole core,
+// so the rest of the timeout is slept off then.
+func readWithin(handle PacketIO, timeout time.Duration) ([]byte, gopacket.CaptureInfo, error) {
+	start := time.Now()
+	data, info, err := handle.ReadPacketData()
+
+	if err == pcap.NextErrorTimeoutExpired {
+		if rest := timeout - time.Since(start); rest > 0 {
+			time.Sleep(rest)
+		}
+	}
+
+	return data, info, err
+}
+
+// isPermissionError : Tells whether opening a PCAP handle failed because we
+// lack the privileges to do so.
+func isPermissionError(err error) bool {
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+
+	message := strings.ToLower(err.Error())
+
+	return strings.Contains(message, "permission denied") || strings.Contains(message, "operation not permitted")
+}
+
+// Close : Closes every PCAP handle in the pool.
+func (pool *Pool) Close() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for iface, shared := range pool.handles {
+		shared.handle.Close()
+		delete(pool.handles, iface)
+	}
+}
+
+// allow : Adds a source address to the BPF filter of the handle, unless it's
+// in there already.
+func (shared *sharedHandle) allow(source net.IP) error {
+	for _, known := range shared.sources {
+		if known.Equal(source) {
+			return nil
+		}
+	}
+
+	sources := append(shared.sources, source)
+
+	if err := shared.handle.SetBPFFilter(bpfFilter(sources)); err != nil {
+		return err
+	}
+
+	shared.sources = sources
+
+	return nil
+}
+
+// bpfFilter : Builds a BPF filter that only passes ARP, ICMPv6 (neighbor
+// discovery among it), and the ICMP unreachable errors and echo replies and TCP
+// packets, on the ports we probe from, sent to one of our source addresses, so
+// the kernel drops everything else before we ever have to parse it.
+func bpfFilter(sources []net.IP) string {
+	hosts := make([]string, len(sources))
+
+	for i, source := range sources {
+		hosts[i] = "dst host " + source.String()
+	}
+
+	filter := fmt.Sprintf("arp or icmp6 or ((icmp[icmptype] == icmp-unreach or icmp[icmptype] == icmp-echoreply or tcp dst portrange %d-%d) and (%s))", minSourcePort, maxSourcePort, strings.Join(hosts, " or "))
+
+	// Replies on a VLAN only match once the filter looks past the tag.
+	return fmt.Sprintf("%s or (vlan and (%s))", filter, filter)
+}
+
+// demultiplex : Reads every packet off the PCAP handle and hands it to the
+// scanners interested in its sender, until the handle is closed.
+func (shared *sharedHandle) demultiplex() {
+	packets := newDecoder()
+
+	for {
+		data, info, err := shared.handle.ReadPacketData()
+
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			return
+		}
+
+		// Packets that can't be parsed in full still have their sender, as
+		// long as the network layer made it.
+		packets.decode(data)
+		sender := packets.senderAddress()
+
+		if sender == "" {
+			continue
+		}
+
+		shared.mutex.Lock()
+
+		for _, poolHandle := range shared.subscribers[sender] {
+			// Never block the reader on a slow scanner, the packet will just
+			// look lost to it.
+			select {
+			case poolHandle.packets <- capturedPacket{data, info}:
+			default:
+			}
+		}
+
+		shared.mutex.Unlock()
+	}
+}
+
+// ReadPacketData : Returns the next packet for this scanner, along with when it
+// was captured, or pcap.NextErrorTimeoutExpired if none arrived in time.
+func (poolHandle *PoolHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	timer := time.NewTimer(poolHandle.shared.timeout)
+	defer timer.Stop()
+
+	select {
+	case packet := <-poolHandle.packets:
+		return packet.data, packet.info, nil
+	case <-timer.C:
+		return nil, gopacket.CaptureInfo{}, pcap.NextErrorTimeoutExpired
+	}
+}
+
+// WritePacketData : Sends a packet out of the shared PCAP handle.
+func (poolHandle *PoolHandle) WritePacketData(data []byte) error {
+	poolHandle.shared.writeMutex.Lock()
+	defer poolHandle.shared.writeMutex.Unlock()
+
+	return poolHandle.shared.handle.WritePacketData(data)
+}
+
+// Close : Stops this scanner from receiving packets. The shared PCAP handle
+// itself stays open until the pool is closed.
+func (poolHandle *PoolHandle) Close() {
+	shared := poolHandle.shared
+
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+
+	for _, key := range poolHandle.addresses {
+		subscribers := shared.subscribers[key]
+
+		for i, subscriber := range subscribers {
+			if subscriber == poolHandle {
+				subscribers = append(subscribers[:i], subscribers[i + 1:]...)
+				break
+			}
+		}
+
+		if len(subscribers) == 0 {
+			delete(shared.subscribers, key)
+		} else {
+			shared.subscribers[key] = subscribers
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// newTestPool : Creates a pool whose interfaces all share the given link, and
+// counts how many times a handle gets opened.
+func newTestPool(link *fakeLink, opened *int) *Pool {
+	pool := NewPool()
+	pool.ReadTimeout = time.Millisecond * 20
+
+	pool.open = func(iface string) (captureHandle, error) {
+		*opened++
+		return link, nil
+	}
+
+	return pool
+}
+
+// resetFrom : Builds a RST sent by the given address to our source address.
+func resetFrom(t testing.TB, ip net.IP) []byte {
+	ip4 := &layers.IPv4{SrcIP: ip, DstIP: net.IP{127, 0, 0, 1}, Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP}
+	tcp := &layers.TCP{SrcPort: 22, DstPort: 50000, RST: true, ACK: true}
+	tcp.SetNetworkLayerForChecksum(ip4)
+
+	return serialize(t, &layers.Ethernet{SrcMAC: fakeHostMAC, DstMAC: fakeEthernet.HardwareAddr, EthernetType: layers.EthernetTypeIPv4}, ip4, tcp)
+}
+
+func TestPoolSharesHandle(t *testing.T) {
+	link := newFakeLink(nil)
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	source := net.IP{127, 0, 0, 1}
+	first, second := net.IP{127, 0, 0, 2}, net.IP{127, 0, 0, 3}
+
+	a, err := pool.Open("fake0", source, first)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Close()
+
+	b, err := pool.Open("fake0", source, second)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	if opened != 1 {
+		t.Errorf("opened %d handles, want 1", opened)
+	}
+
+	if a.shared != b.shared {
+		t.Error("handles on the same interface don't share their PCAP handle")
+	}
+
+	// Every reply goes to the handle of the scanner it's for, and nowhere else.
+	link.inject(resetFrom(t, second))
+	link.inject(resetFrom(t, first))
+
+	for _, c := range []struct {
+		handle *PoolHandle
+		sender net.IP
+	}{
+		{a, first},
+		{b, second},
+	} {
+		data, _, err := c.handle.ReadPacketData()
+
+		if err != nil {
+			t.Fatalf("%s: %v", c.sender, err)
+		}
+
+		packets := newDecoder()
+		packets.decode(data)
+
+		if got := packets.senderAddress(); got != c.sender.String() {
+			t.Errorf("handle of %s got a packet from %s", c.sender, got)
+		}
+
+		if _, _, err := c.handle.ReadPacketData(); err != pcap.NextErrorTimeoutExpired {
+			t.Errorf("handle of %s got another packet, err %v", c.sender, err)
+		}
+	}
+
+	// Handles that are closed don't get anything anymore.
+	a.Close()
+	link.inject(resetFrom(t, first))
+
+	if _, _, err := a.ReadPacketData(); err != pcap.NextErrorTimeoutExpired {
+		t.Errorf("closed handle got a packet, err %v", err)
+	}
+}
+
+// TestPoolConcurrentScans scans a handful of hosts at once through handles of
+// the same pool, which is meant to be run with the race detector.
+func TestPoolConcurrentScans(t *testing.T) {
+	hosts := make(map[string]map[uint16]string)
+
+	for i := 2; i <= 6; i++ {
+		ip := fmt.Sprintf("127.0.0.%d", i)
+
+		hosts[ip] = map[uint16]string{
+			listenSSH(t, ip): portOpen,
+			2201: portClosed,
+			2202: portProhibited,
+		}
+	}
+
+	link := newFakeLink(answerPorts(t, hosts))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	// Everything meant to be shared between the scanners is.
+	metrics := NewMetrics()
+	rtt := NewRTTEstimator(time.Second)
+	idle := NewIdleDetector(10, time.Millisecond * 100)
+	cache := NewMACCache(time.Minute)
+	dials := NewDialLimiter(2)
+
+	states := map[string]PortState{
+		portOpen: Open,
+		portClosed: Closed,
+		portProhibited: Filtered,
+	}
+
+	wg := sync.WaitGroup{}
+
+	for ip, ports := range hosts {
+		dest := net.ParseIP(ip).To4()
+		handle, err := pool.Open("fake0", net.IP{127, 0, 0, 1}, dest)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer handle.Close()
+
+		destPorts := make([]uint16, 0, len(ports))
+
+		for port := range ports {
+			destPorts = append(destPorts, port)
+		}
+
+		scanner := newTestScanner(dest, destPorts, fakeEthernet, handle)
+		scanner.Metrics = metrics
+		scanner.RTT = rtt
+		scanner.Idle = idle
+		scanner.MACCache = cache
+		scanner.Dials = dials
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			results, err := scanner.Scan(context.Background())
+
+			if err != nil {
+				t.Errorf("%s: %v", ip, err)
+				return
+			}
+
+			for _, result := range results {
+				if want := states[ports[result.Port]]; result.State != want {
+					t.Errorf("%s port %d: got %v (%s), want %v", ip, result.Port, result.State, result.Reason, want)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// splitRouter routes the hosts on 127.0.2.0/24 through a second interface,
+// and every other one through fakeEthernet.
+type splitRouter struct {
+	second *net.Interface
+}
+
+// Route : Routes a host through the interface its address belongs to.
+func (router splitRouter) Route(dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	if dst.To4() != nil && dst.To4()[2] == 2 {
+		return router.second, nil, net.IP{127, 0, 0, 1}, nil
+	}
+
+	return fakeEthernet, nil, net.IP{127, 0, 0, 1}, nil
+}
+
+// RouteWithSrc : Routes a host like Route does, whatever the source.
+func (router splitRouter) RouteWithSrc(input net.HardwareAddr, src, dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return router.Route(dst)
+}
+
+// TestPoolGroupsInterfaces scans hosts routed out of two interfaces, and checks
+// that each interface gets a single handle, which only the hosts routed out
+// of it go through.
+func TestPoolGroupsInterfaces(t *testing.T) {
+	second := &net.Interface{Name: "fake1", MTU: 1500, Flags: net.FlagUp | net.FlagBroadcast, HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x03}}
+
+	groups := map[string][]string{
+		"fake0": {"127.0.1.1", "127.0.1.2"},
+		"fake1": {"127.0.2.1", "127.0.2.2"},
+	}
+
+	// Every link only answers for the hosts routed out of its interface, so
+	// hosts sent out of the wrong one never get found.
+	links := map[string]*fakeLink{}
+	ports := map[string]uint16{}
+
+	for iface, ips := range groups {
+		hosts := map[string]map[uint16]string{}
+
+		for _, ip := range ips {
+			ports[ip] = listenSSH(t, ip)
+			hosts[ip] = map[uint16]string{ports[ip]: portOpen}
+		}
+
+		links[iface] = newFakeLink(answerPorts(t, hosts))
+	}
+
+	var mutex sync.Mutex
+	opened := map[string]int{}
+
+	pool := NewPool()
+	pool.ReadTimeout = time.Millisecond * 20
+	defer pool.Close()
+
+	pool.open = func(iface string) (captureHandle, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		opened[iface]++
+		return links[iface], nil
+	}
+
+	wg := sync.WaitGroup{}
+
+	for _, ips := range groups {
+		for _, ip := range ips {
+			wg.Add(1)
+
+			go func(ip string) {
+				defer wg.Done()
+
+				scanner, err := New(net.ParseIP(ip).To4(), Options{
+					Router: splitRouter{second},
+					Pool: pool,
+					Ports: []uint16{ports[ip]},
+					ARPTimeout: time.Millisecond * 300,
+					ScanTimeout: time.Millisecond * 300,
+				})
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				defer scanner.Close()
+
+				results, err := scanner.Scan(context.Background())
+
+				if err != nil {
+					t.Errorf("%s: %v", ip, err)
+					return
+				}
+
+				if len(results) != 1 || results[0].State != Open {
+					t.Errorf("%s: got %+v, want the port to be open", ip, results)
+				}
+			}(ip)
+		}
+	}
+
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(opened) != 2 || opened["fake0"] != 1 || opened["fake1"] != 1 {
+		t.Errorf("got handles opened %v, want one per interface", opened)
+	}
+}
+
+func TestBPFFilter(t *testing.T) {
+	for _, c := range []struct {
+		sources []net.IP
+		hosts string
+	}{
+		{[]net.IP{{192, 168, 1, 10}}, "dst host 192.168.1.10"},
+		{[]net.IP{net.ParseIP("fd00::10")}, "dst host fd00::10"},
+		{[]net.IP{{192, 168, 1, 10}, net.ParseIP("fd00::10")}, "dst host 192.168.1.10 or dst host fd00::10"},
+	} {
+		filter := "arp or icmp6 or ((icmp[icmptype] == icmp-unreach or icmp[icmptype] == icmp-echoreply or tcp dst portrange 1024-65535) and (" + c.hosts + "))"
+		want := filter + " or (vlan and (" + filter + "))"
+
+		if got := bpfFilter(c.sources); got != want {
+			t.Errorf("%v: got %q, want %q", c.sources, got, want)
+		}
+
+		// And libpcap takes it.
+		if _, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, 65535, want); err != nil {
+			t.Errorf("%v: %v", c.sources, err)
+		}
+	}
+
+	// Every source address of the handles of an interface ends up in the
+	// filter once.
+	link := newFakeLink(nil)
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	for _, source := range []net.IP{{192, 168, 1, 10}, {192, 168, 1, 11}, {192, 168, 1, 10}} {
+		handle, err := pool.Open("fake0", source, net.IP{192, 168, 1, 1})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer handle.Close()
+	}
+
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+
+	if want := bpfFilter([]net.IP{{192, 168, 1, 10}, {192, 168, 1, 11}}); link.filter != want {
+		t.Errorf("got filter %q, want %q", link.filter, want)
+	}
+}
+
+// recordedHandle is an inactiveHandle that records what was set on it.
+type recordedHandle struct {
+	snapLen int
+	promisc bool
+	timeout time.Duration
+	bufferSize int
+}
+
+func (handle *recordedHandle) SetSnapLen(snaplen int) error {
+	handle.snapLen = snaplen
+	return nil
+}
+
+func (handle *recordedHandle) SetPromisc(promisc bool) error {
+	handle.promisc = promisc
+	return nil
+}
+
+func (handle *recordedHandle) SetTimeout(timeout time.Duration) error {
+	handle.timeout = timeout
+	return nil
+}
+
+func (handle *recordedHandle) SetBufferSize(bufferSize int) error {
+	handle.bufferSize = bufferSize
+	return nil
+}
+
+func TestConfigureHandle(t *testing.T) {
+	handle := &recordedHandle{}
+
+	if err := configureHandle(handle, time.Millisecond * 50, 8 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	want := recordedHandle{snapLen: snapLen, promisc: true, timeout: time.Millisecond * 50, bufferSize: 8 << 20}
+
+	if *handle != want {
+		t.Errorf("got %+v, want %+v", *handle, want)
+	}
+}
+
+func TestIsPermissionError(t *testing.T) {
+	tests := []struct {
+		err error
+		want bool
+	}{
+		{errors.New("eth0: You don't have permission to capture on that device (socket: Operation not permitted)"), true},
+		{errors.New("lo: socket: Permission denied"), true},
+		{fmt.Errorf("opening eth0: %w", os.ErrPermission), true},
+		{errors.New("eth9: No such device exists (SIOCGIFHWADDR: No such device)"), false},
+		{errors.New("eth0: That device is not up"), false},
+	}
+
+	for _, test := range tests {
+		if got := isPermissionError(test.err); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+// spinningLink is a PacketIO whose reads give up right away, counting them.
+type spinningLink struct {
+	reads int
+}
+
+func (link *spinningLink) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	link.reads++
+	return nil, gopacket.CaptureInfo{}, pcap.NextErrorTimeoutExpired
+}
+
+func (link *spinningLink) WritePacketData(data []byte) error {
+	return nil
+}
+
+// TestReadWithin checks that waiting for packets that never come doesn't spin
+// on reads that give up right away, and takes just as long.
+func TestReadWithin(t *testing.T) {
+	link := &spinningLink{}
+	start := time.Now()
+
+	for time.Since(start) < time.Millisecond * 200 {
+		if _, _, err := readWithin(link, time.Millisecond * 20); err != pcap.NextErrorTimeoutExpired {
+			t.Fatalf("got %v, want the read timed out", err)
+		}
+	}
+
+	// A read every 20ms, give or take the last one.
+	if link.reads < 5 || link.reads > 11 {
+		t.Errorf("got %d reads in 200ms, want about 10", link.reads)
+	}
+}
@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// ResolveAll : Resolves where packets to each of the hosts go, with the given
+// number of workers, so that the addresses are already in opts.MACCache by the
+// time the hosts get scanned and the scans don't wait on ARP one after the
+// other. Hosts behind the same gateway only get resolved once. It returns the
+// error of every host that didn't answer, keyed on its address, which scanning
+// it would only run into again. Resolving a host gets as long as its
+// HostTimeout, which starts over when it's scanned, so that hosts waiting on
+// the others to be resolved don't run out of time before they're probed.
+//
+// It does nothing without a MACCache to fill, or for connect scans, other than
+// draining hosts, so that whatever feeds it doesn't get stuck. Once ctx is done
+// it stops reading from hosts, so feeding it should stop then too. The cache
+// should be held (see MACCache.Hold) until the hosts are scanned.
+func ResolveAll(ctx context.Context, hosts <-chan net.IP, opts Options, workers int) map[string]error {
+	failures := map[string]error{}
+
+	if opts.MACCache == nil || opts.Connect {
+		for range hosts {
+		}
+
+		return failures
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	targets := make(chan net.IP, workers)
+	var mutex sync.Mutex
+
+	// A wait group that will help us wait until all the workers are done.
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ip := range targets {
+				if err := resolveHost(ctx, ip, opts); err != nil {
+					mutex.Lock()
+					failures[ip.String()] = err
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	// Stop queueing hosts once we've been cancelled.
+	for ip := range hosts {
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case targets <- ip:
+		case <-ctx.Done():
+		}
+	}
+
+	close(targets)
+	wg.Wait()
+
+	return failures
+}
+
+// resolveHost : Creates a scanner for a single IP just to resolve where its
+// packets go, and cleans up.
+func resolveHost(ctx context.Context, ip net.IP, opts Options) error {
+	scanner, err := New(ip, opts)
+
+	// Let the scan itself report hosts it can't even be set up for, and leave
+	// the ones it connects to alone.
+	if err != nil {
+		return nil
+	}
+
+	defer scanner.Close()
+
+	if scanner.Connect {
+		return nil
+	}
+
+	// Don't spend longer on the host than its scan could. Running out of time
+	// (or being cancelled) isn't a failure, the scan gets to try again.
+	if scanner.HostTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, scanner.HostTimeout)
+		defer cancel()
+	}
+
+	// Hosts on the link get probed anyway when discovery is skipped.
+	if _, err := scanner.DestMACAddress(ctx); err != nil && ctx.Err() == nil {
+		if scanner.SkipDiscovery && scanner.Gateway == nil {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeHosts : Returns the addresses of n fake hosts, and what they answer
+// for.
+func fakeHosts(n int) ([]net.IP, map[string]map[uint16]string) {
+	ips := make([]net.IP, n)
+	hosts := make(map[string]map[uint16]string, n)
+
+	for i := range ips {
+		ips[i] = net.IP{127, 0, 1, byte(i + 1)}
+		hosts[ips[i].String()] = map[uint16]string{}
+	}
+
+	return ips, hosts
+}
+
+// feedHosts : Feeds the hosts to ResolveAll.
+func feedHosts(ips []net.IP) <-chan net.IP {
+	hosts := make(chan net.IP)
+
+	go func() {
+		defer close(hosts)
+
+		for _, ip := range ips {
+			hosts <- ip
+		}
+	}()
+
+	return hosts
+}
+
+func TestResolveAll(t *testing.T) {
+	ips, hosts := fakeHosts(4)
+
+	// One host doesn't answer ARP at all.
+	quiet := net.IP{127, 0, 1, 99}
+	ips = append(ips, quiet)
+
+	link := newFakeLink(answerPorts(t, hosts))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	opts := Options{
+		Router: fakeRouter{},
+		Pool: pool,
+		ARPTimeout: time.Millisecond * 200,
+		HostTimeout: time.Minute,
+		MACCache: NewMACCache(time.Millisecond),
+	}
+
+	opts.MACCache.Hold()
+	failures := ResolveAll(context.Background(), feedHosts(ips), opts, 4)
+
+	if len(failures) != 1 || failures[quiet.String()] == nil {
+		t.Errorf("got failures %v, want just %s", failures, quiet)
+	}
+
+	// The addresses don't expire while the cache is held, so the scans don't
+	// have to ask for them again.
+	time.Sleep(time.Millisecond * 10)
+
+	link.mutex.Lock()
+	written := link.written
+	link.mutex.Unlock()
+
+	for _, ip := range ips[:4] {
+		hwaddr, err := opts.MACCache.Resolve(context.Background(), ip, func() (net.HardwareAddr, error) {
+			return nil, fmt.Errorf("Resolved %s again", ip)
+		})
+
+		if err != nil || hwaddr.String() != fakeHostMAC.String() {
+			t.Errorf("%s: got %v, %v", ip, hwaddr, err)
+		}
+	}
+
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+
+	if link.written != written {
+		t.Errorf("sent %d more packets", link.written - written)
+	}
+
+	opts.MACCache.Release()
+}
+
+// BenchmarkResolve compares resolving hosts in turn, like scans used to when
+// they started, to resolving them all up front, with ARP replies that take a
+// few milliseconds.
+func BenchmarkResolve(b *testing.B) {
+	ips, hosts := fakeHosts(32)
+
+	link := newFakeLink(answerPorts(b, hosts))
+	link.delay = time.Millisecond * 5
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	opts := Options{
+		Router: fakeRouter{},
+		Pool: pool,
+		ARPTimeout: time.Second,
+	}
+
+	b.Run("in-turn", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			opts.MACCache = NewMACCache(time.Minute)
+
+			for _, ip := range ips {
+				if err := resolveHost(context.Background(), ip, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("phased", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			opts.MACCache = NewMACCache(time.Minute)
+
+			if failures := ResolveAll(context.Background(), feedHosts(ips), opts, 16); len(failures) > 0 {
+				b.Fatal(failures)
+			}
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket/routing"
+)
+
+// ForcedRoute : Works out how to reach ip through the named interface rather
+// than the one the router would pick, which may be the wrong one on hosts with
+// several NICs or VPNs. The source address is taken from the interface. Hosts
+// off its networks need the router's gateway, so they can only be reached
+// through it if the router picked the same interface.
+func ForcedRoute(router routing.Router, name string, ip net.IP) (*net.Interface, net.IP, net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	addrs, err := iface.Addrs()
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Find an address of the same family as the target, preferring one from
+	// the same network.
+	var src net.IP
+	onLink := false
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+
+		if !ok || (ipnet.IP.To4() == nil) != (ip.To4() == nil) {
+			continue
+		}
+
+		if ipnet.Contains(ip) {
+			src = normalize(ipnet.IP)
+			onLink = true
+			break
+		}
+
+		if src == nil {
+			src = normalize(ipnet.IP)
+		}
+	}
+
+	if src == nil {
+		family := "IPv4"
+
+		if ip.To4() == nil {
+			family = "IPv6"
+		}
+
+		return nil, nil, nil, fmt.Errorf("Interface %s has no %s address", name, family)
+	}
+
+	// Hosts on the interface's own network are reached directly.
+	if onLink {
+		return iface, nil, src, nil
+	}
+
+	// Everything else goes through the gateway, as long as the router agrees
+	// on the interface. The gateway of another interface isn't on this one's
+	// network, and the host itself isn't either, so there's nothing to send
+	// the packets to.
+	routed, gateway, _, err := router.Route(ip)
+
+	if err != nil || routed.Name != iface.Name {
+		return nil, nil, nil, fmt.Errorf("%v isn't on the network of %s, and isn't routed through it", ip, name)
+	}
+
+	return iface, gateway, src, nil
+}
+
+// onLink : Tells whether ip is on one of the networks of the interface, where
+// it's reached directly rather than through a gateway.
+func onLink(iface *net.Interface, ip net.IP) bool {
+	addrs, err := iface.Addrs()
+
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalize : Returns the 4 byte form of IPv4 addresses and the 16 byte form of
+// IPv6 ones.
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip.To16()
+}
@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"net"
+	"testing"
+)
+
+// gatewayRouter routes every host through an interface and a gateway, even the
+// ones on the network of the interface, like some routing tables do.
+type gatewayRouter struct {
+	iface *net.Interface
+	gateway net.IP
+}
+
+// Route : Routes a host through the gateway.
+func (router gatewayRouter) Route(dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return router.iface, router.gateway, net.IP{127, 0, 0, 1}, nil
+}
+
+// RouteWithSrc : Routes a host through the gateway, whatever the source.
+func (router gatewayRouter) RouteWithSrc(input net.HardwareAddr, src, dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return router.Route(dst)
+}
+
+// loopback : Returns the loopback interface, which is on 127.0.0.0/8.
+func loopback(t *testing.T) *net.Interface {
+	for _, name := range []string{"lo", "lo0"} {
+		if iface, err := net.InterfaceByName(name); err == nil {
+			return iface
+		}
+	}
+
+	t.Skip("no loopback interface")
+	return nil
+}
+
+func TestRouteOnLink(t *testing.T) {
+	lo := loopback(t)
+	gateway := net.IP{127, 0, 0, 254}
+	link := newFakeLink(nil)
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	router := gatewayRouter{lo, gateway}
+
+	tests := []struct {
+		ip net.IP
+		gateway net.IP
+	}{
+		// Hosts on the network of the interface are reached directly,
+		// whatever the router says.
+		{net.IP{127, 0, 0, 2}, nil},
+		{net.IP{127, 1, 2, 3}, nil},
+
+		// The rest go through the gateway.
+		{net.IP{10, 0, 0, 5}, gateway},
+	}
+
+	for _, test := range tests {
+		scanner, err := New(test.ip, Options{Router: router, Pool: pool, Ports: []uint16{22}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !scanner.Gateway.Equal(test.gateway) {
+			t.Errorf("%v: got gateway %v, want %v", test.ip, scanner.Gateway, test.gateway)
+		}
+
+		scanner.Close()
+
+		// Forcing the interface comes to the same.
+		_, forcedGateway, src, err := ForcedRoute(router, lo.Name, test.ip)
+
+		if err != nil || !forcedGateway.Equal(test.gateway) || !src.Equal(net.IP{127, 0, 0, 1}) {
+			t.Errorf("%v through %s: got gateway %v, source %v, %v", test.ip, lo.Name, forcedGateway, src, err)
+		}
+	}
+
+	// The gateway of another interface doesn't help with the one forced, so
+	// hosts off its network can't be reached through it.
+	if _, forcedGateway, _, err := ForcedRoute(gatewayRouter{fakeEthernet, gateway}, lo.Name, net.IP{10, 0, 0, 5}); err == nil {
+		t.Errorf("10.0.0.5 through %s, routed through %s: got gateway %v, want an error", lo.Name, fakeEthernet.Name, forcedGateway)
+	}
+
+	// Hosts on its network still can.
+	if _, forcedGateway, _, err := ForcedRoute(gatewayRouter{fakeEthernet, gateway}, lo.Name, net.IP{127, 0, 0, 2}); err != nil || forcedGateway != nil {
+		t.Errorf("127.0.0.2 through %s, routed through %s: got gateway %v, %v", lo.Name, fakeEthernet.Name, forcedGateway, err)
+	}
+}
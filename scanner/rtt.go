@@ -0,0 +1,77 @@
+package scanner
+
+import (
+	"sync"
+	"time"
+)
+
+// minRTTTimeout is the least time hosts get to answer, however fast the others
+// have been.
+const minRTTTimeout = time.Millisecond * 100
+
+// RTTEstimator keeps a moving average of the round-trip times measured while
+// scanning, and works out from it how long to wait for replies, like TCP does
+// for its retransmission timeout. It's safe to share between scanners.
+type RTTEstimator struct {
+	// The longest time to wait for replies, which is also how long to wait
+	// before any round-trip time has been measured.
+	Max time.Duration
+
+	srtt time.Duration
+	rttvar time.Duration
+	samples int
+	mutex sync.Mutex
+}
+
+// NewRTTEstimator : Creates an estimator that never waits longer than max.
+func NewRTTEstimator(max time.Duration) *RTTEstimator {
+	return &RTTEstimator{Max: max}
+}
+
+// Observe : Takes a measured round-trip time into account.
+func (estimator *RTTEstimator) Observe(rtt time.Duration) {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+
+	// The first sample sets the average, the ones after it move it by an
+	// eighth of the difference, and the variation by a quarter (RFC 6298).
+	if estimator.samples == 0 {
+		estimator.srtt = rtt
+		estimator.rttvar = rtt / 2
+	} else {
+		diff := estimator.srtt - rtt
+
+		if diff < 0 {
+			diff = -diff
+		}
+
+		estimator.rttvar = (estimator.rttvar * 3 + diff) / 4
+		estimator.srtt = (estimator.srtt * 7 + rtt) / 8
+	}
+
+	estimator.samples++
+}
+
+// Timeout : Returns how long to wait for replies, which is the average
+// round-trip time plus four times its variation, between minRTTTimeout and
+// Max.
+func (estimator *RTTEstimator) Timeout() time.Duration {
+	estimator.mutex.Lock()
+	defer estimator.mutex.Unlock()
+
+	if estimator.samples == 0 {
+		return estimator.Max
+	}
+
+	t := estimator.srtt + estimator.rttvar * 4
+
+	if t < minRTTTimeout {
+		t = minRTTTimeout
+	}
+
+	if t > estimator.Max {
+		t = estimator.Max
+	}
+
+	return t
+}
@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	estimator := NewRTTEstimator(time.Second * 3)
+
+	// Nothing measured yet, so hosts get the longest wait.
+	if timeout := estimator.Timeout(); timeout != time.Second * 3 {
+		t.Errorf("before any sample: got %v, want 3s", timeout)
+	}
+
+	// The averages as RFC 6298 works them out after each sample.
+	samples := []struct {
+		rtt time.Duration
+		srtt time.Duration
+		rttvar time.Duration
+		timeout time.Duration
+	}{
+		{time.Millisecond * 80, time.Millisecond * 80, time.Millisecond * 40, time.Millisecond * 240},
+		{time.Millisecond * 160, time.Millisecond * 90, time.Millisecond * 50, time.Millisecond * 290},
+		{time.Millisecond * 90, time.Millisecond * 90, time.Microsecond * 37500, time.Millisecond * 240},
+		{time.Second * 4, time.Microsecond * 578750, time.Microsecond * 1005625, time.Second * 3},
+	}
+
+	for i, sample := range samples {
+		estimator.Observe(sample.rtt)
+
+		if estimator.srtt != sample.srtt || estimator.rttvar != sample.rttvar {
+			t.Errorf("sample %d: got srtt %v, rttvar %v, want %v, %v", i, estimator.srtt, estimator.rttvar, sample.srtt, sample.rttvar)
+		}
+
+		if timeout := estimator.Timeout(); timeout != sample.timeout {
+			t.Errorf("sample %d: got timeout %v, want %v", i, timeout, sample.timeout)
+		}
+	}
+
+	// However fast hosts answer, they get at least minRTTTimeout.
+	fast := NewRTTEstimator(time.Second * 3)
+	fast.Observe(time.Millisecond)
+
+	if timeout := fast.Timeout(); timeout != minRTTTimeout {
+		t.Errorf("fast host: got %v, want %v", timeout, minRTTTimeout)
+	}
+}
@@ -0,0 +1,1461 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/routing"
+	"golang.org/x/time/rate"
+)
+
+// Scanner handles scanning a single IP address.
+type Scanner struct {
+	// The interface is the interface to SendPacket packets on, and its MTU.
+	Interface *net.Interface
+	MTU int
+
+	// All the IP addresses that we need to send and receive packets.
+	DestIP net.IP
+	Gateway net.IP
+	SourceIP net.IP
+
+	// The TCP ports we're probing on DestIP, and the port the probes are sent
+	// from. Each further destination port is probed from the next source port,
+	// unless FixedSrcPort is set.
+	DestPorts []uint16
+	SrcPort uint16
+	FixedSrcPort bool
+
+	// How long to wait for ARP (or neighbor discovery) replies and for replies
+	// to the probes. Zero means DefaultTimeout.
+	ARPTimeout time.Duration
+	ScanTimeout time.Duration
+
+	// The most time spent on the host as a whole, if it's set, which starts
+	// counting when it's scanned.
+	HostTimeout time.Duration
+
+	// How long services get to send their banner. Zero means
+	// DefaultBannerTimeout.
+	BannerTimeout time.Duration
+
+	// What kind of probes to send, and whether to skip them altogether and
+	// just connect to the ports instead.
+	ScanType ScanType
+	Connect bool
+
+	// The TTL (or IPv6 hop limit) of the probes, where zero means DefaultTTL,
+	// and their IPv4 ID, which is random for every probe unless FixedIPID is
+	// set.
+	TTL uint8
+	IPID uint16
+	FixedIPID bool
+
+	// Whether IPv4 probes go without the Don't Fragment bit, and whether they
+	// are split into fragments.
+	AllowFragments bool
+	Fragment bool
+
+	// The TCP window of the probes, where zero means DefaultWindow, and the
+	// MSS option they carry, if it's set.
+	Window uint16
+	MSS uint16
+
+	// How many times a request that got no reply is sent again, and how long
+	// to wait before doing so. Zero means DefaultRetransmit. ARP requests and
+	// neighbor solicitations back off from there, up to MaxBackoff.
+	Retries int
+	RetransmitInterval time.Duration
+	MaxBackoff time.Duration
+
+	// How many probes to send every port, counting the replies, if it's more
+	// than one.
+	Count int
+
+	// Limits the rate packets are sent at, if it's set.
+	Limiter *rate.Limiter
+
+	// The 802.1Q VLAN to tag packets with, if it's set.
+	VLAN uint16
+
+	// Bounds the connections open at once, if it's set.
+	Dials *DialLimiter
+
+	// Whether to grab the banners of every port over TLS, rather than just
+	// those of the ports that usually speak it.
+	TLS bool
+
+	// Whether to grab banners with an HTTP request instead.
+	HTTP bool
+
+	// The probers grabbing the banners of specific ports, if any.
+	Probers map[uint16]Prober
+
+	// Whether to answer SYN/ACKs with a RST.
+	Reset bool
+
+	// Whether to leave the banners of possible tarpits alone.
+	SkipTarpits bool
+
+	// Whether to make sure the host is up before probing it, and whether to
+	// probe it whatever discovery says.
+	Ping bool
+	SkipDiscovery bool
+
+	// Works out how long to wait for replies from the round-trip times seen
+	// so far, instead of always waiting the ScanTimeout, if it's set.
+	RTT *RTTEstimator
+
+	// Shortens the wait for replies while the hosts scanned before have all
+	// stayed quiet, if it's set.
+	Idle *IdleDetector
+
+	// Counts the packets sent and received and the results, if it's set.
+	Metrics *Metrics
+
+	// Gets every result once the host is done, if it's set.
+	OnResult func(Result)
+
+	// Parses the packets read off the Handle.
+	packets *decoder
+
+	// Remembers the network addresses of neighbors, if it's set.
+	MACCache *MACCache
+
+	// Where diagnostics go, if anywhere.
+	Logger *slog.Logger
+
+	// The handle packets are read from and written to. It's normally shared
+	// with the other scanners on the same interface.
+	Handle PacketIO
+
+	// The following help to easily serialize packets in the SendPacket() method.
+	SerializeOptions gopacket.SerializeOptions
+	Buffer gopacket.SerializeBuffer
+}
+
+// PacketIO is where a scanner reads and writes raw packets. *pcap.Handle,
+// *PoolHandle and the handles scanners open on their own satisfy it, as can anything replaying packets for testing.
+type PacketIO interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	WritePacketData(data []byte) error
+}
+
+var (
+	_ PacketIO = (*pcap.Handle)(nil)
+	_ PacketIO = (*PoolHandle)(nil)
+	_ PacketIO = (*liveHandle)(nil)
+)
+
+// DefaultTimeout is how long replies are waited for unless told otherwise.
+const DefaultTimeout = time.Second * 3
+
+// ErrHostTimeout is returned by Scan when the HostTimeout of the host ran out
+// before any of its ports could be probed, such as while waiting on ARP.
+var ErrHostTimeout = errors.New("Host timed out before its ports were probed")
+
+// ErrNoNeighbor is returned when the host, or its gateway, didn't answer ARP
+// or neighbor discovery before the ARPTimeout ran out.
+var ErrNoNeighbor = errors.New("Host wasn't found on the network")
+
+// Options holds the settings of a scanner, which are normally shared by all
+// the scanners of a run.
+type Options struct {
+	// The TCP ports to probe on the target.
+	Ports []uint16
+
+	// How long to wait for replies. Zero means DefaultTimeout.
+	ARPTimeout time.Duration
+	ScanTimeout time.Duration
+
+	// The most time to spend on a single host, ARP and probes included. Zero
+	// means there's no limit besides the timeouts above.
+	HostTimeout time.Duration
+
+	// How long a connection made to grab a banner stays open, however slow
+	// the service is to send it, such as tarpits that never do. Whatever was
+	// sent by then is taken as the banner. Zero means DefaultBannerTimeout.
+	BannerTimeout time.Duration
+
+	// What kind of probes to send, SYNScan unless told otherwise.
+	ScanType ScanType
+
+	// The source address to send packets from instead of the one the route
+	// comes with, for when replies to it are known to make their way back.
+	// It has to be of the same family as the target, and doesn't work for
+	// connect scans.
+	SourceIP net.IP
+
+	// Whether to scan by connecting to the ports, which works without root
+	// but only tells open ports from the rest. Interface, Router and Pool
+	// aren't used, and the ScanType has to be SYNScan.
+	Connect bool
+
+	// The TTL (or IPv6 hop limit) of the probes. Zero means DefaultTTL.
+	TTL uint8
+
+	// The IPv4 ID of the probes. Unless FixedIPID is set, every probe gets a
+	// random one instead.
+	IPID uint16
+	FixedIPID bool
+
+	// Whether to leave the Don't Fragment bit of IPv4 packets unset, which is
+	// otherwise set like the kernel does for TCP connections, so that routers
+	// may fragment the probes.
+	AllowFragments bool
+
+	// Whether to split IPv4 probes into fragments of 8 bytes, so that the TCP
+	// header is spread over several of them, for testing whether firewalls
+	// reassemble packets before filtering them. It implies AllowFragments.
+	// IPv6 probes are sent whole.
+	Fragment bool
+
+	// The source port to send every probe from, such as one that makes it
+	// through egress firewalls, from 1024 up. Zero means a random port for
+	// every scanner, moving on to the next one for every further destination
+	// port. Connect scans leave the source port to the kernel.
+	SourcePort uint16
+
+	// The TCP window of the probes, so they look like they come from an actual
+	// client. Zero means DefaultWindow.
+	Window uint16
+
+	// The MSS option to send along with the probes. Zero means the probes go
+	// without one.
+	MSS uint16
+
+	// How many times to send ARP requests and probes again when they get no
+	// reply, and how often. Zero means DefaultRetransmit.
+	Retries int
+	RetransmitInterval time.Duration
+
+	// ARP requests (and neighbor solicitations) wait twice as long before
+	// every resend, give or take some jitter so that many scanners don't send
+	// them all at once, but never longer than this. Zero means
+	// DefaultMaxBackoff.
+	MaxBackoff time.Duration
+
+	// How many probes to send every port, one every RetransmitInterval, to
+	// gauge packet loss from how many of them get a reply, which ends up in
+	// the Count of the results. Retries don't apply then, since every probe
+	// gets sent anyway. Zero or one means a single probe, resent as usual.
+	// Connect scans ignore it.
+	Count int
+
+	// Limits the rate at which packets are sent. Share one limiter between all
+	// the scanners of a run to limit the rate of the run as a whole. If it's
+	// nil, packets are sent as fast as possible.
+	Limiter *rate.Limiter
+
+	// The 802.1Q VLAN ID (1 to 4094) to tag packets with, for interfaces that
+	// carry tagged traffic. Zero means packets go out untagged.
+	VLAN uint16
+
+	// Bounds how many connections (to grab banners, or for connect scans) are
+	// open at once. Share one limiter between all the scanners of a run. If
+	// it's nil, there's no bound.
+	Dials *DialLimiter
+
+	// Whether to grab banners with a TLS handshake on every open port, such
+	// as for services on unusual ports. Ports like 443 that usually speak TLS
+	// always get one.
+	TLS bool
+
+	// Whether to grab banners by sending open ports a HEAD request, and
+	// taking the status line and Server header of the response as the banner,
+	// for scanning web servers. Services that aren't HTTP servers get an
+	// "Unable to get banner" banner instead.
+	HTTP bool
+
+	// The probers that grab the banners of specific ports, such as one
+	// sending EHLO to port 25, instead of reading what the service sends on
+	// its own (or sending a HEAD request, if HTTP is set). Ports that usually
+	// speak TLS, or every port if TLS is set, are probed over TLS.
+	Probers map[uint16]Prober
+
+	// Whether to answer the SYN/ACKs of open ports with a RST, so that the
+	// targets don't keep the connections the probes half opened around until
+	// they time out. The kernel usually does this on its own, since it knows
+	// nothing of the probes, unless a firewall drops its RSTs.
+	Reset bool
+
+	// Whether to skip grabbing the banner of ports that look like tarpits,
+	// going by the tiny window of their SYN/ACK (see Result.Tarpit), which
+	// would only hold on to the connection until the BannerTimeout.
+	SkipTarpits bool
+
+	// Whether to only probe hosts that are up, going by whether they answer
+	// ARP (or neighbor discovery) when they're on the link, and an ICMP (or
+	// ICMPv6) echo request otherwise. Scan returns ErrHostDown for the ones
+	// that aren't, without sending them a single probe. Connect scans can't
+	// ping, so they probe every host.
+	Ping bool
+
+	// Whether to probe every host, whether it's up or not: Ping is ignored,
+	// and hosts on the link that don't answer ARP (or neighbor discovery)
+	// get their probes sent to the Ethernet broadcast (or all-nodes
+	// multicast) address instead of failing, for hosts that drop both pings
+	// and ARP but still have ports open. Hosts behind a gateway that doesn't
+	// answer still fail, since nothing is going to forward their probes.
+	SkipDiscovery bool
+
+	// Measures round-trip times and waits for replies to the probes based on
+	// them, which is shorter than the ScanTimeout on fast networks. Share one
+	// estimator between all the scanners of a run so that they learn from
+	// each other. If it's nil, the ScanTimeout is used as is.
+	RTT *RTTEstimator
+
+	// Notices when the hosts scanned have stopped answering altogether, such
+	// as when a whole range is unreachable, and waits only its Timeout for
+	// replies from then on, until a host answers again. Share one detector
+	// between all the scanners of a run, like the RTT estimator. If it's nil,
+	// the wait is never shortened.
+	Idle *IdleDetector
+
+	// Whether to leave the checksums of the packets sent for the NIC to fill
+	// in, for NICs offloading them that would otherwise compute them again or
+	// reject the packets. On NICs that don't, the packets go out with broken
+	// checksums and get dropped.
+	NoChecksums bool
+
+	// Counts the packets sent, the replies received and the results of every
+	// host, for monitoring. Share one between all the scanners of a run. If
+	// it's nil, nothing is counted.
+	Metrics *Metrics
+
+	// Gets called with every result of a host as soon as Scan is done with
+	// it, including those of hosts that couldn't be scanned, for sending the
+	// results somewhere as they come in. It's called from the goroutine
+	// calling Scan, before Scan returns, so a callback shared between
+	// scanners running at the same time has to be safe for concurrent use,
+	// such as by locking a mutex, and a slow callback holds up the scan that
+	// called it.
+	OnResult func(Result)
+
+	// Remembers the network addresses resolved by ARP or neighbor discovery,
+	// so that scanners going through the same gateway share its address. If
+	// it's nil, every scanner resolves the address on its own.
+	MACCache *MACCache
+
+	// Where diagnostics (as opposed to results) are logged. If it's nil,
+	// they're dropped.
+	Logger *slog.Logger
+
+	// The name of the interface to send packets out of. If it's empty, the
+	// router picks one.
+	Interface string
+
+	// The router used to figure out how to reach the target. If it's nil, a
+	// new one is created for the scanner.
+	Router routing.Router
+
+	// The pool the scanner gets its PCAP handle from. If it's nil, the scanner
+	// opens a handle of its own, which is closed along with the scanner.
+	Pool *Pool
+
+	// How long a read of the scanner's own PCAP handle may block, which is
+	// how often it checks whether it's been cancelled or has timed out while
+	// waiting for replies. Zero means DefaultReadTimeout. Scanners using a
+	// Pool go by the pool's ReadTimeout instead.
+	ReadTimeout time.Duration
+
+	// The size of the capture buffer of the scanner's own PCAP handle, in
+	// bytes. Replies that come in while it's full are dropped, which makes
+	// their ports look filtered. Zero means libpcap's default, a few MB on
+	// Linux. Scanners using a Pool go by the pool's BufferSize instead.
+	BufferSize int
+}
+
+// New : Initialize a new scanner that will scan the target IP address.
+func New(ip net.IP, opts Options) (*Scanner, error) {
+	// Initialize a new Scanner.
+	scanner := &Scanner{
+		// Set the destination IP and ports, and pick a fresh source port so
+		// replies to other scans can't be mistaken for ours.
+		DestIP: ip,
+		DestPorts: opts.Ports,
+		SrcPort: RandomSourcePort(),
+		FixedSrcPort: opts.SourcePort != 0,
+
+		// How long to wait for replies, and how hard to try getting them.
+		ARPTimeout: opts.ARPTimeout,
+		ScanTimeout: opts.ScanTimeout,
+		HostTimeout: opts.HostTimeout,
+		BannerTimeout: opts.BannerTimeout,
+		ScanType: opts.ScanType,
+		Connect: opts.Connect,
+		TTL: opts.TTL,
+		IPID: opts.IPID,
+		FixedIPID: opts.FixedIPID,
+		AllowFragments: opts.AllowFragments || opts.Fragment,
+		Fragment: opts.Fragment,
+		Window: opts.Window,
+		MSS: opts.MSS,
+		Retries: opts.Retries,
+		RetransmitInterval: opts.RetransmitInterval,
+		MaxBackoff: opts.MaxBackoff,
+		Count: opts.Count,
+		Limiter: opts.Limiter,
+		VLAN: opts.VLAN,
+		Dials: opts.Dials,
+		TLS: opts.TLS,
+		HTTP: opts.HTTP,
+		Probers: opts.Probers,
+		Reset: opts.Reset,
+		SkipTarpits: opts.SkipTarpits,
+		Ping: opts.Ping,
+		SkipDiscovery: opts.SkipDiscovery,
+		RTT: opts.RTT,
+		Idle: opts.Idle,
+		Metrics: opts.Metrics,
+		OnResult: opts.OnResult,
+		MACCache: opts.MACCache,
+		Logger: opts.Logger,
+
+		// And set the helper options and buffer.
+		Buffer: gopacket.NewSerializeBuffer(),
+		SerializeOptions: gopacket.SerializeOptions{
+			FixLengths: true,
+			ComputeChecksums: !opts.NoChecksums,
+		},
+	}
+
+	// Replies to ports below the range aren't let through by the BPF filter.
+	if opts.SourcePort != 0 {
+		if opts.SourcePort < minSourcePort {
+			return nil, fmt.Errorf("Source port %d is below %d", opts.SourcePort, minSourcePort)
+		}
+
+		scanner.SrcPort = opts.SourcePort
+	}
+
+	// Connect scans leave routing and capturing to the kernel.
+	if opts.Connect {
+		if opts.ScanType != SYNScan {
+			return nil, fmt.Errorf("Connect scans can't do %s scans", opts.ScanType)
+		}
+
+		if opts.SourceIP != nil {
+			return nil, fmt.Errorf("Connect scans can't use another source IP")
+		}
+
+		return scanner, nil
+	}
+
+	router := opts.Router
+	var err error
+
+	if router == nil {
+		if router, err = routing.New(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Figure out the route to the IP address of choice, unless we were told
+	// which interface to use.
+	var iface *net.Interface
+	var gateway, src net.IP
+
+	if opts.Interface != "" {
+		iface, gateway, src, err = ForcedRoute(router, opts.Interface, ip)
+	} else {
+		iface, gateway, src, err = router.Route(ip)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Some routing tables send hosts on our own network through the gateway
+	// too, but those hosts answer ARP themselves.
+	if gateway != nil && onLink(iface, ip) {
+		gateway = nil
+	}
+
+	// Send from somewhere else if told to, and listen for replies to there.
+	if opts.SourceIP != nil {
+		if (opts.SourceIP.To4() == nil) != (ip.To4() == nil) {
+			return nil, fmt.Errorf("Source IP %s can't be used for %s", opts.SourceIP, ip)
+		}
+
+		src = normalize(opts.SourceIP)
+	}
+
+	scanner.Gateway = gateway
+	scanner.SourceIP = src
+	scanner.Interface = iface
+	scanner.MTU = iface.MTU
+	scanner.checkMTU()
+
+	// Tunnels and the like carry no Ethernet frames for us to craft, so scan
+	// by connecting instead where that works.
+	if iface.Flags & net.FlagLoopback == 0 && (iface.Flags & net.FlagPointToPoint != 0 || len(iface.HardwareAddr) == 0) {
+		if opts.ScanType != SYNScan || opts.SourceIP != nil {
+			return nil, fmt.Errorf("Interface %s has no link layer to send raw packets on", iface.Name)
+		}
+
+		scanner.logger().Debug("Connecting instead of sending raw packets", "interface", iface.Name)
+		scanner.Connect = true
+
+		return scanner, nil
+	}
+
+	// Without a pool, open a PCAP handle of our own.
+	if opts.Pool == nil {
+		pcapHandle, err := openLive(iface.Name, opts.ReadTimeout, opts.BufferSize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pcapHandle.SetBPFFilter(bpfFilter([]net.IP{src})); err != nil {
+			pcapHandle.Close()
+			return nil, err
+		}
+
+		scanner.Handle = pcapHandle
+
+		return scanner, nil
+	}
+
+	// Grab a handle on the interface's shared PCAP handle, listening for
+	// packets from the target and from the gateway that answers ARP for it.
+	poolHandle, err := opts.Pool.Open(iface.Name, src, ip, gateway)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scanner.Handle = poolHandle
+
+	return scanner, nil
+}
+
+// DefaultRetransmit is how long to wait for a reply before sending a packet
+// again, unless told otherwise.
+const DefaultRetransmit = time.Second
+
+// retransmit : Tells whether it's time to (re)send a request, given how many
+// times it was sent already and when it was sent last.
+func (scanner *Scanner) retransmit(sends int, lastSent time.Time) bool {
+	if sends == 0 {
+		return true
+	}
+
+	return sends <= scanner.Retries && time.Since(lastSent) >= scanner.retransmitInterval()
+}
+
+// retransmitInterval : Returns how long to wait for a reply before sending a
+// request again.
+func (scanner *Scanner) retransmitInterval() time.Duration {
+	if scanner.RetransmitInterval <= 0 {
+		return DefaultRetransmit
+	}
+
+	return scanner.RetransmitInterval
+}
+
+// DefaultMaxBackoff is the longest wait between ARP requests unless told
+// otherwise.
+const DefaultMaxBackoff = time.Second * 8
+
+// backoff : Returns how long to wait for a reply to a request that was sent
+// the given number of times before sending it again. The wait doubles with
+// every send up to the MaxBackoff, and is then spread by up to a quarter
+// either way.
+func (scanner *Scanner) backoff(sends int) time.Duration {
+	interval := scanner.RetransmitInterval
+
+	if interval <= 0 {
+		interval = DefaultRetransmit
+	}
+
+	limit := scanner.MaxBackoff
+
+	if limit <= 0 {
+		limit = DefaultMaxBackoff
+	}
+
+	for i := 1; i < sends && interval < limit; i++ {
+		interval *= 2
+	}
+
+	if interval > limit {
+		interval = limit
+	}
+
+	return interval * 3 / 4 + time.Duration(rand.Int63n(int64(interval / 2) + 1))
+}
+
+// scanTimeout : Returns how long to wait for replies to the probes, which is
+// shortened while the hosts before stayed quiet.
+func (scanner *Scanner) scanTimeout() time.Duration {
+	if scanner.RTT != nil {
+		return scanner.Idle.shorten(scanner.RTT.Timeout())
+	}
+
+	return scanner.Idle.shorten(timeout(scanner.ScanTimeout))
+}
+
+// observe : Hands a round-trip time to the RTT estimator, if there is one.
+// Only replies to requests that were sent once can be timed, since there's no
+// telling which send a reply to a resent one answers.
+func (scanner *Scanner) observe(sends int, rtt time.Duration) {
+	if scanner.RTT != nil && sends == 1 {
+		scanner.RTT.Observe(rtt)
+	}
+}
+
+// since : Returns how long after sent a packet was captured. That's going by
+// its capture timestamp rather than by when we got around to reading it, which
+// may be a lot later when banners were grabbed in the meantime, unless it
+// doesn't have one.
+func since(sent time.Time, info gopacket.CaptureInfo) time.Duration {
+	if info.Timestamp.IsZero() {
+		return time.Since(sent)
+	}
+
+	if elapsed := info.Timestamp.Sub(sent); elapsed > 0 {
+		return elapsed
+	}
+
+	return 0
+}
+
+// DefaultWindow is the TCP window of the probes unless told otherwise, which is
+// what Linux clients start connections with.
+const DefaultWindow = 64240
+
+// setOptions : Sets the window of a probe and the TCP options it carries.
+func (scanner *Scanner) setOptions(tcp *layers.TCP) {
+	tcp.Window = scanner.Window
+
+	if tcp.Window == 0 {
+		tcp.Window = DefaultWindow
+	}
+
+	if scanner.MSS != 0 {
+		tcp.Options = append(tcp.Options, layers.TCPOption{
+			OptionType: layers.TCPOptionKindMSS,
+			OptionLength: 4,
+			OptionData: []byte{byte(scanner.MSS >> 8), byte(scanner.MSS)},
+		})
+	}
+}
+
+// DefaultTTL is the TTL of the probes unless told otherwise.
+const DefaultTTL = 64
+
+// ttl : Returns the TTL of the probes.
+func (scanner *Scanner) ttl() uint8 {
+	if scanner.TTL == 0 {
+		return DefaultTTL
+	}
+
+	return scanner.TTL
+}
+
+// logger : Returns the logger of the scanner, or one that drops everything.
+func (scanner *Scanner) logger() *slog.Logger {
+	return loggerOrDiscard(scanner.Logger)
+}
+
+// loggerOrDiscard : Returns the given logger, or one that drops everything if
+// it's nil.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return logger
+}
+
+// timeout : Returns the given timeout, or DefaultTimeout if it isn't set.
+func timeout(t time.Duration) time.Duration {
+	if t <= 0 {
+		return DefaultTimeout
+	}
+
+	return t
+}
+
+// DestMACAddress : Gets the network address packets to the host go to, which
+// is the one of its gateway, unless it's on the link or the gateway doesn't
+// answer but the host itself does.
+func (scanner *Scanner) DestMACAddress(ctx context.Context) (net.HardwareAddr, error) {
+	// Nothing answers ARP on the loopback interface, where every address is
+	// all zeroes.
+	if scanner.Interface.Flags & net.FlagLoopback != 0 {
+		return make(net.HardwareAddr, 6), nil
+	}
+
+	if scanner.Gateway == nil {
+		return scanner.resolveNeighbor(ctx, scanner.DestIP)
+	}
+
+	hwaddr, err := scanner.resolveNeighbor(ctx, scanner.Gateway)
+
+	// Hosts routed through a gateway that doesn't answer may still be right
+	// there on the link, such as behind proxy ARP or a netmask that's too
+	// narrow, so they get asked themselves before giving up.
+	if err != nil && ctx.Err() == nil {
+		direct, directErr := scanner.resolveNeighbor(ctx, scanner.DestIP)
+
+		if directErr == nil {
+			scanner.logger().Debug("Gateway didn't answer, resolved the host directly", "ip", scanner.DestIP, "gateway", scanner.Gateway, "err", err)
+			return direct, nil
+		}
+
+		scanner.logger().Debug("Neither the gateway nor the host answered", "ip", scanner.DestIP, "gateway", scanner.Gateway, "err", directErr)
+	}
+
+	return hwaddr, err
+}
+
+// resolveNeighbor : Gets the network address of a neighbor, through ARP or
+// neighbor discovery, or the MACCache if it's set.
+func (scanner *Scanner) resolveNeighbor(ctx context.Context, arpDst net.IP) (net.HardwareAddr, error) {
+	resolve := func() (net.HardwareAddr, error) {
+		// IPv6 has no ARP, it uses neighbor discovery instead.
+		if scanner.DestIP.To4() == nil {
+			return scanner.NeighborMACAddress(ctx, arpDst)
+		}
+
+		return scanner.ARPMACAddress(ctx, arpDst)
+	}
+
+	// Scanners going through the same gateway only need to ask for its
+	// address once.
+	if scanner.MACCache != nil {
+		return scanner.MACCache.Resolve(ctx, arpDst, resolve)
+	}
+
+	return resolve()
+}
+
+// ARPMACAddress : Gets the network address of an IPv4 neighbor using ARP.
+func (scanner *Scanner) ARPMACAddress(ctx context.Context, arpDst net.IP) (net.HardwareAddr, error) {
+	start := time.Now()
+
+	// Prepare the layers to SendPacket for an ARP request.
+	eth := layers.Ethernet{
+		SrcMAC: scanner.Interface.HardwareAddr,
+		DstMAC: net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeARP,
+	}
+
+	arp := layers.ARP{
+		AddrType: layers.LinkTypeEthernet,
+		Protocol: layers.EthernetTypeIPv4,
+		HwAddressSize: 6,
+		ProtAddressSize: 4,
+		Operation: layers.ARPRequest,
+		SourceHwAddress: []byte(scanner.Interface.HardwareAddr),
+		SourceProtAddress: []byte(scanner.SourceIP),
+		DstHwAddress: []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress: []byte(arpDst),
+	}
+
+	nextSend := time.Time{}
+	sends := 0
+
+	// Wait for an ARP reply and then return the address.
+	for {
+		// Send the ARP packet, and send it again, backing off, if no reply
+		// shows up.
+		if sends <= scanner.Retries && !time.Now().Before(nextSend) {
+			if err := scanner.SendPacket(ctx, &eth, &arp); err != nil {
+				return nil, err
+			}
+
+			sends++
+			nextSend = time.Now().Add(scanner.backoff(sends))
+		}
+
+		// Has the scan been cancelled?
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Has time run out?
+		if time.Since(start) > timeout(scanner.ARPTimeout) {
+			return nil, fmt.Errorf("%w: no ARP reply within %v", ErrNoNeighbor, timeout(scanner.ARPTimeout))
+		}
+
+		data, info, err := scanner.Handle.ReadPacketData()
+
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		packets := scanner.decoder()
+		packets.decode(data)
+
+		if packets.has(layers.LayerTypeARP) {
+			arp := &packets.arp
+
+			// Only take actual replies from the host we asked about, not
+			// requests or probes that happen to come from it, and not ones
+			// that don't say where it is.
+			if arp.Operation == layers.ARPReply && net.IP(arp.SourceProtAddress).Equal(net.IP(arpDst)) && !zeroAddress(arp.SourceHwAddress) {
+				// Hosts on the link take as long to answer ARP as probes,
+				// gateways don't tell us anything about the hosts behind them.
+				if scanner.Gateway == nil {
+					scanner.observe(sends, since(start, info))
+				}
+
+				scanner.Metrics.received()
+
+				return append(net.HardwareAddr(nil), arp.SourceHwAddress...), nil
+			}
+		}
+	}
+}
+
+// decoder : Returns the decoder for the packets read off the Handle.
+func (scanner *Scanner) decoder() *decoder {
+	if scanner.packets == nil {
+		scanner.packets = newDecoder()
+	}
+
+	return scanner.packets
+}
+
+// zeroAddress : Tells whether a hardware address is all zeroes.
+func zeroAddress(hwaddr []byte) bool {
+	for _, b := range hwaddr {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// probeKey is the source and destination port of a probe.
+type probeKey struct {
+	src layers.TCPPort
+	dst layers.TCPPort
+}
+
+// ipLayer is the IPv4 or IPv6 layer of the packets we send.
+type ipLayer interface {
+	gopacket.NetworkLayer
+	gopacket.SerializableLayer
+}
+
+// PortState is what we found out about a port.
+type PortState int
+
+const (
+	// Filtered ports never answered, so it's the state every port starts in.
+	Filtered PortState = iota
+
+	// Open ports answered the SYN with a SYN/ACK.
+	Open
+
+	// Closed ports answered the SYN with a RST.
+	Closed
+
+	// OpenFiltered ports didn't answer a FIN, NULL or Xmas probe, which open
+	// and filtered ports alike don't.
+	OpenFiltered
+
+	// DialFailed ports answered the SYN with a SYN/ACK, but wouldn't take the
+	// connection made to grab their banner.
+	DialFailed
+)
+
+// String : Returns the name of the state.
+func (state PortState) String() string {
+	switch state {
+	case Open:
+		return "open"
+	case Closed:
+		return "closed"
+	case OpenFiltered:
+		return "open|filtered"
+	case DialFailed:
+		return "dial-failed"
+	default:
+		return "filtered"
+	}
+}
+
+// MarshalText : Makes the state show up by name in JSON.
+func (state PortState) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
+
+// Result is the outcome of scanning a single port on a host.
+type Result struct {
+	IP string `json:"ip"`
+
+	// The hostname the IP was resolved from, if it was.
+	Hostname string `json:"hostname,omitempty"`
+
+	Port uint16 `json:"port"`
+	State PortState `json:"state"`
+	Banner string `json:"banner"`
+
+	// What the SSH identification string in the banner says, such as "2.0",
+	// "OpenSSH_9.6p1" and "Ubuntu-3ubuntu13". They're empty for banners that
+	// aren't one.
+	Protocol string `json:"protocol,omitempty"`
+	Software string `json:"software,omitempty"`
+	Comments string `json:"comments,omitempty"`
+
+	// What the TLS handshake told about the port, for ports whose banner was
+	// grabbed over TLS.
+	TLS *TLSInfo `json:"tls,omitempty"`
+
+	// What the ICMP destination unreachable error a router or firewall
+	// answered the probe with said, such as "admin-prohibited", which makes
+	// the port filtered. It's empty for ports that got no such error.
+	Unreachable string `json:"unreachable,omitempty"`
+
+	// Why the port got its state, going by what it answered the probe with:
+	// "syn-ack", "reset", "conn-refused", "no-response", "icmp-" followed by
+	// the unreachable code, "arp-timeout" (or "nd-timeout") for hosts that
+	// were never found on the network, "host-timeout" for hosts that ran out
+	// of time before being probed, or "error" for hosts that failed otherwise.
+	Reason string `json:"reason,omitempty"`
+
+	// Whether the port may be a tarpit, such as LaBrea, which answers every
+	// SYN with a SYN/ACK advertising a window of a few bytes (or none at
+	// all) to trap whoever connects. Actual servers advertise thousands.
+	Tarpit bool `json:"tarpit,omitempty"`
+
+	// How many probes were sent to the port and how many of them it answered,
+	// when several probes were sent to it to gauge packet loss.
+	Count *ProbeCount `json:"count,omitempty"`
+
+	// When the port was done being scanned, and how long it took to answer
+	// the last probe sent to it, if it did.
+	Time time.Time `json:"timestamp"`
+	RTT time.Duration `json:"-"`
+
+	// Why the port couldn't be scanned, such as the host not answering ARP or
+	// the probe not going out. It's empty for ports that were scanned, even
+	// if they never answered.
+	Error string `json:"error,omitempty"`
+}
+
+// ProbeCount is how many of the probes sent to a port got a reply.
+type ProbeCount struct {
+	Sent int `json:"sent"`
+	Replied int `json:"replied"`
+}
+
+// FailedResults : Returns the results of a host that couldn't be scanned at
+// all because of err, with every port filtered and the error set. The reason
+// says whether the host wasn't found on the network, ran out of time before
+// being probed, or failed some other way, such as a packet that couldn't be
+// sent.
+func FailedResults(ip net.IP, ports []uint16, err error) []Result {
+	results := make([]Result, len(ports))
+	reason := "error"
+
+	switch {
+	case errors.Is(err, ErrHostTimeout):
+		reason = "host-timeout"
+	case errors.Is(err, ErrNoNeighbor) && ip.To4() == nil:
+		reason = "nd-timeout"
+	case errors.Is(err, ErrNoNeighbor):
+		reason = "arp-timeout"
+	}
+
+	for i, port := range ports {
+		results[i] = Result{
+			IP: ip.String(),
+			Port: port,
+			Time: time.Now(),
+			Reason: reason,
+			Error: err.Error(),
+		}
+	}
+
+	return results
+}
+
+// setBanner : Sets the banner of the result, and what it says about the SSH
+// server behind it.
+func (result *Result) setBanner(banner string) {
+	result.Banner = banner
+	result.Protocol, result.Software, result.Comments = ParseBanner(banner)
+}
+
+// Scan scans the DestIP IP address of this scanner and returns a result
+// for each of the DestPorts. Ports that don't answer before the ScanTimeout, or
+// before the HostTimeout runs out, are reported as Filtered (or OpenFiltered,
+// for the scan types open ports don't answer). If the host can't be scanned,
+// such as when it doesn't answer ARP or runs out of its HostTimeout before
+// being probed (ErrHostTimeout), the error comes with results that have
+// it set as their Error, unless the scan was cancelled. Hosts that don't answer
+// the ping, when Ping is set, get no results at all and ErrHostDown. Every
+// result goes to OnResult too, if it's set.
+func (scanner *Scanner) Scan(ctx context.Context) ([]Result, error) {
+	start := time.Now()
+	results, err := scanner.scan(ctx)
+
+	for i := range results {
+		results[i].Time = time.Now()
+	}
+
+	if err == nil {
+		scanner.Metrics.scanned(results, time.Since(start))
+		scanner.Idle.Observe(results)
+	}
+
+	if scanner.OnResult != nil {
+		for _, result := range results {
+			scanner.OnResult(result)
+		}
+	}
+
+	return results, err
+}
+
+// scan : Does the actual scanning for Scan.
+func (scanner *Scanner) scan(ctx context.Context) ([]Result, error) {
+	// Every port is filtered (or open|filtered, depending on the scan type)
+	// until it answers.
+	results := make([]Result, len(scanner.DestPorts))
+
+	for i, port := range scanner.DestPorts {
+		results[i] = Result{
+			IP: scanner.DestIP.String(),
+			Port: port,
+			State: scanner.ScanType.Silent(),
+			Reason: "no-response",
+		}
+	}
+
+	// Bound the time spent on this host as a whole, ARP included. Running out
+	// of it once the probes are out isn't a failure, whatever didn't answer by
+	// then is just filtered, but running out before that is.
+	parent := ctx
+
+	if scanner.HostTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, scanner.HostTimeout)
+		defer cancel()
+	}
+
+	// Connect scans go through the kernel and need none of the crafting below.
+	if scanner.Connect {
+		return scanner.connectScan(ctx, parent, results)
+	}
+
+	// Before we do anything, we ensure we have the MAC address of where
+	// we're sending packets to.
+	hwaddr, err := scanner.DestMACAddress(ctx)
+
+	// Hosts on the link that don't answer ARP may still answer probes when
+	// discovery is skipped, so those go to every host on the link instead.
+	if err != nil && scanner.SkipDiscovery && scanner.Gateway == nil && ctx.Err() == nil {
+		scanner.logger().Debug("No address resolved, probing through broadcast", "ip", scanner.DestIP, "err", err)
+		hwaddr, err = linkBroadcast(scanner.DestIP), nil
+	}
+
+	if err != nil {
+		if ctx.Err() != nil && parent.Err() == nil {
+			err = ErrHostTimeout
+		}
+
+		// Being cancelled says nothing about the host, not answering does.
+		if parent.Err() != nil {
+			return nil, err
+		}
+
+		return FailedResults(scanner.DestIP, scanner.DestPorts, err), err
+	}
+
+	// Construct all the network layers we need. The loopback interface has no
+	// network address of its own, so its frames come from all zeroes too.
+	srcMAC := scanner.Interface.HardwareAddr
+
+	if len(srcMAC) == 0 {
+		srcMAC = make(net.HardwareAddr, 6)
+	}
+
+	eth := layers.Ethernet{
+		SrcMAC: srcMAC,
+		DstMAC: hwaddr,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	// Craft the IP portion, which is IPv4 unless the target is an IPv6 host.
+	var ip ipLayer = &layers.IPv4{
+		SrcIP: scanner.SourceIP,
+		DstIP: scanner.DestIP,
+		Version: 4,
+		TTL: scanner.ttl(),
+		Id: scanner.IPID,
+		Flags: scanner.ipFlags(),
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	endpoint := layers.EndpointIPv4
+
+	if scanner.DestIP.To4() == nil {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		endpoint = layers.EndpointIPv6
+
+		ip = &layers.IPv6{
+			SrcIP: scanner.SourceIP,
+			DstIP: scanner.DestIP,
+			Version: 6,
+			HopLimit: scanner.ttl(),
+			NextHeader: layers.IPProtocolTCP,
+		}
+	}
+
+	// Hosts that are down don't get probed at all.
+	if scanner.Ping && !scanner.SkipDiscovery {
+		up, err := scanner.alive(ctx, &eth)
+
+		if err != nil {
+			if ctx.Err() != nil && parent.Err() == nil {
+				err = ErrHostTimeout
+			}
+
+			if parent.Err() != nil {
+				return nil, err
+			}
+
+			return FailedResults(scanner.DestIP, scanner.DestPorts, err), err
+		}
+
+		if !up {
+			return nil, ErrHostDown
+		}
+	}
+
+	// Create the flow we expect returning packets to have, so we can check
+	// against it and discard useless packets.
+	netFlow := gopacket.NewFlow(endpoint, scanner.DestIP, scanner.SourceIP)
+
+	// The probes still waiting for an answer, keyed on the source port we send
+	// them from and the port they go to. Source ports only repeat when there
+	// are more ports to scan than there are source ports.
+	probes := make(map[probeKey]*Result, len(scanner.DestPorts))
+
+	// Every probe gets a random sequence number, like connections do, which
+	// replies have to acknowledge, and which resends of the probe keep.
+	seqs := make(map[probeKey]uint32, len(scanner.DestPorts))
+
+	// When every probe was last sent, which its reply is timed from.
+	sent := make(map[probeKey]time.Time, len(scanner.DestPorts))
+
+	for i, port := range scanner.DestPorts {
+		key := probeKey{scanner.sourcePort(i), layers.TCPPort(port)}
+		probes[key] = &results[i]
+		seqs[key] = rand.Uint32()
+	}
+
+	// Counting replies to several probes per port takes a loop of its own.
+	if scanner.Count > 1 {
+		return scanner.countScan(ctx, parent, results, probes, seqs, &eth, ip, netFlow)
+	}
+
+	start := time.Now()
+	lastSent := time.Time{}
+	sends := 0
+	timed := false
+
+	for {
+		// Every probe got its answer, so there's nothing left to wait for.
+		if len(probes) == 0 {
+			return results, nil
+		}
+
+		// We SendPacket one packet to each of the DestPorts, which are the
+		// ports we're looking for, and send it again to the ones that stay
+		// quiet in case it got lost.
+		if scanner.retransmit(sends, lastSent) {
+			for key, result := range probes {
+				if err := scanner.sendProbe(ctx, &eth, ip, key, seqs[key]); err != nil {
+					scanner.logger().Warn("Error sending probe", "ip", scanner.DestIP, "port", result.Port, "err", err)
+					result.Error = err.Error()
+				}
+
+				sent[key] = time.Now()
+			}
+
+			lastSent = time.Now()
+			sends++
+		}
+
+		// Has the scan been cancelled, or has the host run out of time?
+		if err := ctx.Err(); err != nil {
+			if parent.Err() == nil {
+				return results, nil
+			}
+
+			return nil, err
+		}
+
+		// Set a timeout if no response was received.
+		if time.Since(start) > scanner.scanTimeout() {
+			return results, nil
+		}
+
+		// Read in the next packet.
+		data, info, err := scanner.Handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			scanner.logger().Warn("Error reading packet", "ip", scanner.DestIP, "err", err)
+			continue
+		}
+
+		// Here we need to parse the packet in order to conduct some checks as to
+		// whether it's the one we're looking for.
+		packets := scanner.decoder()
+		packets.decode(data)
+
+		// A router or firewall on the way may answer a probe with an ICMP
+		// error instead, in which case there's no reply to wait for.
+		if dst, key, code, ok := packets.unreachable(); ok {
+			if result, probed := probes[key]; probed && dst.Equal(scanner.DestIP) {
+				delete(probes, key)
+				scanner.Metrics.received()
+
+				result.Error = ""
+				result.State = Filtered
+				result.Unreachable = code
+				result.Reason = "icmp-" + code
+			}
+
+			continue
+		}
+
+		flow, ok := packets.networkFlow()
+		tcp := &packets.tcp
+
+		if ok && flow == netFlow && packets.has(layers.LayerTypeTCP) {
+			key := probeKey{tcp.DstPort, tcp.SrcPort}
+			result, probed := probes[key]
+
+			// Replies that don't acknowledge our probe answer something else,
+			// such as a probe of an earlier scan from the same ports, and
+			// duplicates of replies we already got find no probe left.
+			if !probed || tcp.Ack != scanner.ScanType.acked(seqs[key]) {
+				continue
+			}
+
+			scanner.Metrics.received()
+
+			// Whatever went wrong sending the probe before, it got through.
+			// It's timed from when it was last sent, to when the reply came
+			// in, however long ago banners kept us from reading it.
+			result.Error = ""
+			result.RTT = since(sent[key], info)
+
+			if !timed {
+				scanner.observe(sends, result.RTT)
+				timed = true
+			}
+
+			// This *is* the packet we're looking for...
+			if scanner.ScanType == SYNScan && tcp.SYN && tcp.ACK {
+				delete(probes, key)
+
+				result.State = Open
+				result.Reason = "syn-ack"
+				result.Tarpit = isTarpit(tcp)
+
+				// Don't leave the target holding on to a half-open
+				// connection until it times out.
+				if scanner.Reset {
+					if err := scanner.sendReset(ctx, &eth, ip, tcp); err != nil {
+						scanner.logger().Debug("Error sending reset", "ip", result.IP, "port", result.Port, "err", err)
+					}
+				}
+
+				if result.Tarpit && scanner.SkipTarpits {
+					scanner.logger().Debug("Skipping banner of possible tarpit", "ip", result.IP, "port", result.Port, "window", tcp.Window)
+				} else if banner, info, err := scanner.banner(ctx, result.Port); err != nil {
+					scanner.logger().Debug("Unable to connect", "ip", result.IP, "port", result.Port, "err", err)
+					result.State = DialFailed
+				} else {
+					result.setBanner(banner)
+					result.TLS = info
+				}
+
+				// Grabbing the banner takes a while, so give the remaining
+				// probes their full time to answer.
+				start = time.Now()
+			} else if tcp.RST {
+				// ...or the port is closed.
+				delete(probes, key)
+
+				result.State = Closed
+				result.Reason = "reset"
+			}
+		}
+	}
+}
+
+// maxTarpitWindow is the largest window a SYN/ACK can advertise and still look
+// like it comes from a tarpit. LaBrea advertises 10 bytes by default.
+const maxTarpitWindow = 64
+
+// isTarpit : Tells whether a SYN/ACK looks like it comes from a tarpit, going by
+// its tiny window.
+func isTarpit(synAck *layers.TCP) bool {
+	return synAck.Window <= maxTarpitWindow
+}
+
+// The range of source ports probes are sent from, which stays clear of the
+// well-known ports.
+const (
+	minSourcePort = 1024
+	maxSourcePort = 65535
+)
+
+// RandomSourcePort : Picks a random port out of the dynamic range (49152 and
+// up) to send probes from.
+func RandomSourcePort() uint16 {
+	return uint16(49152 + rand.Intn(maxSourcePort - 49152 + 1))
+}
+
+// sourcePort : The source port the i-th destination port is probed from, so
+// that replies to simultaneous probes can be told apart. A fixed source port is
+// used for every destination port, whose replies are told apart by the port
+// they come from.
+func (scanner *Scanner) sourcePort(i int) layers.TCPPort {
+	if scanner.FixedSrcPort {
+		return layers.TCPPort(scanner.SrcPort)
+	}
+
+	return layers.TCPPort(minSourcePort + (int(scanner.SrcPort) - minSourcePort + i) % (maxSourcePort - minSourcePort + 1))
+}
+
+// sendProbe : Sends the probe of a port, a plain-ole SYN packet or whatever the
+// scan type calls for, with the given sequence number.
+func (scanner *Scanner) sendProbe(ctx context.Context, eth *layers.Ethernet, ip ipLayer, key probeKey, seq uint32) error {
+	tcp := layers.TCP{
+		SrcPort: key.src,
+		DstPort: key.dst,
+		Seq: seq,
+	}
+
+	scanner.ScanType.setFlags(&tcp)
+	scanner.setOptions(&tcp)
+
+	// Give every packet an IP ID of its own, unless told which one to use.
+	if ip4, ok := ip.(*layers.IPv4); ok && !scanner.FixedIPID {
+		ip4.Id = uint16(rand.Intn(65536))
+	}
+
+	// Set the checksum of the network.
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	if ip4, ok := ip.(*layers.IPv4); ok && scanner.Fragment {
+		return scanner.sendFragments(ctx, eth, ip4, &tcp)
+	}
+
+	return scanner.SendPacket(ctx, eth, ip, &tcp)
+}
+
+// sendReset : Answers a SYN/ACK with a RST, which tears down the connection the
+// probe half opened on the target.
+func (scanner *Scanner) sendReset(ctx context.Context, eth *layers.Ethernet, ip ipLayer, synAck *layers.TCP) error {
+	rst := layers.TCP{
+		SrcPort: synAck.DstPort,
+		DstPort: synAck.SrcPort,
+		Seq: synAck.Ack,
+		RST: true,
+	}
+
+	rst.SetNetworkLayerForChecksum(ip)
+
+	return scanner.SendPacket(ctx, eth, ip, &rst)
+}
+
+// SendPacket : This function sends a packet, as serialized by gopacket.
+func (scanner *Scanner) SendPacket(ctx context.Context, l ...gopacket.SerializableLayer) error {
+	// Wait for our turn if the packet rate is limited.
+	if scanner.Limiter != nil {
+		if err := scanner.Limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Tag the packet with our VLAN, right between the Ethernet layer and
+	// whatever it carries.
+	if eth, ok := l[0].(*layers.Ethernet); ok && scanner.VLAN != 0 {
+		tagged := *eth
+		tagged.EthernetType = layers.EthernetTypeDot1Q
+
+		dot1q := layers.Dot1Q{
+			VLANIdentifier: scanner.VLAN,
+			Type: eth.EthernetType,
+		}
+
+		l = append([]gopacket.SerializableLayer{&tagged, &dot1q}, l[1:]...)
+	}
+
+	if err := gopacket.SerializeLayers(scanner.Buffer, scanner.SerializeOptions, l...); err != nil {
+		return err
+	}
+
+	// A full send buffer only means we're sending faster than the NIC keeps
+	// up with, so give it a moment to drain and try again.
+	for attempt := 0; ; attempt++ {
+		err := scanner.Handle.WritePacketData(scanner.Buffer.Bytes())
+
+		if err == nil {
+			break
+		}
+
+		if attempt == maxSendRetries || !isBufferFull(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(time.Millisecond << attempt):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	scanner.Metrics.sent()
+
+	return nil
+}
+
+// maxSendRetries is how many times a packet is sent again when the send buffer
+// of the interface is full.
+const maxSendRetries = 4
+
+// isBufferFull : Tells whether sending a packet failed because the send buffer
+// of the interface is full (ENOBUFS). libpcap only passes on the message of
+// the error.
+func isBufferFull(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || strings.Contains(strings.ToLower(err.Error()), "no buffer space available")
+}
+
+// Close : This function releases the Handle, if it needs releasing.
+func (scanner *Scanner) Close() {
+	if closer, ok := scanner.Handle.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
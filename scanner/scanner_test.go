@@ -0,0 +1,1514 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/time/rate"
+)
+
+func TestScan(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+
+	ports := map[uint16]string{
+		open: portOpen,
+		2201: portClosed,
+		2202: portProhibited,
+		2203: portSilent,
+	}
+
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": ports}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open, 2201, 2202, 2203}, fakeEthernet, link)
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint16]struct {
+		state PortState
+		reason string
+	}{
+		open: {Open, "syn-ack"},
+		2201: {Closed, "reset"},
+		2202: {Filtered, "icmp-admin-prohibited"},
+		2203: {Filtered, "no-response"},
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+
+	for _, result := range results {
+		w := want[result.Port]
+
+		if result.State != w.state || result.Reason != w.reason {
+			t.Errorf("port %d: got %v (%s), want %v (%s)", result.Port, result.State, result.Reason, w.state, w.reason)
+		}
+
+		if result.IP != "127.0.0.2" {
+			t.Errorf("port %d: got IP %s", result.Port, result.IP)
+		}
+	}
+
+	for _, result := range results {
+		if result.Port == open && result.Software != "OpenSSH_9.6p1" {
+			t.Errorf("open port: got software %q, banner %q", result.Software, result.Banner)
+		}
+	}
+}
+
+// TestScanMoreHostsThanWorkers checks that hosts waiting on the others to be
+// scanned after being resolved up front still get their whole HostTimeout,
+// so that their open ports get probed and reported open.
+func TestScanMoreHostsThanWorkers(t *testing.T) {
+	ips, hosts := fakeHosts(6)
+	ports := make(map[string]uint16, len(ips))
+
+	// Every scan takes long enough for the scans after it to run out of time
+	// if their clocks started when they were resolved.
+	for _, ip := range ips {
+		ports[ip.String()] = listenSlowSSH(t, ip.String(), time.Millisecond * 200)
+		hosts[ip.String()][ports[ip.String()]] = portOpen
+	}
+
+	link := newFakeLink(answerPorts(t, hosts))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	opts := Options{
+		Router: fakeRouter{},
+		Pool: pool,
+		HostTimeout: time.Millisecond * 700,
+		MACCache: NewMACCache(time.Minute),
+	}
+
+	opts.MACCache.Hold()
+	defer opts.MACCache.Release()
+
+	if failures := ResolveAll(context.Background(), feedHosts(ips), opts, len(ips)); len(failures) > 0 {
+		t.Fatalf("got failures %v", failures)
+	}
+
+	targets := feedHosts(ips)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var results []Result
+
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ip := range targets {
+				scanOpts := opts
+				scanOpts.Ports = []uint16{ports[ip.String()]}
+
+				scanner, err := New(ip, scanOpts)
+
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+
+				found, err := scanner.Scan(context.Background())
+				scanner.Close()
+
+				if err != nil {
+					t.Errorf("%s: %v", ip, err)
+				}
+
+				mutex.Lock()
+				results = append(results, found...)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(results) != len(ips) {
+		t.Fatalf("got %d results, want %d", len(results), len(ips))
+	}
+
+	for _, result := range results {
+		if result.State != Open || result.Error != "" {
+			t.Errorf("%s: got %v (%s), error %q, want open", result.IP, result.State, result.Reason, result.Error)
+		}
+	}
+}
+
+// TestScanHostTimeout checks that a host that runs out of its HostTimeout
+// before it's probed gets reported as not scanned rather than as filtered.
+func TestScanHostTimeout(t *testing.T) {
+	link := newFakeLink(nil)
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{22}, fakeEthernet, link)
+	scanner.ARPTimeout = time.Second
+	scanner.HostTimeout = time.Millisecond * 50
+
+	results, err := scanner.Scan(context.Background())
+
+	if !errors.Is(err, ErrHostTimeout) {
+		t.Errorf("got error %v, want %v", err, ErrHostTimeout)
+	}
+
+	if len(results) != 1 || results[0].Error == "" || results[0].Reason != "host-timeout" {
+		t.Errorf("got %+v, want the host to be reported as not scanned", results)
+	}
+}
+
+// TestScanRTT checks that the round-trip time of a port doesn't take in the
+// time spent grabbing the banners of the ports that answered before it.
+func TestScanRTT(t *testing.T) {
+	first := listenSlowSSH(t, "127.0.0.2", time.Millisecond * 200)
+	second := listenSlowSSH(t, "127.0.0.2", time.Millisecond * 200)
+
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {first: portOpen, second: portOpen}}))
+	link.delay = time.Millisecond * 2
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{first, second}, fakeEthernet, link)
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.State != Open {
+			t.Errorf("port %d: got %v (%s), want open", result.Port, result.State, result.Reason)
+		}
+
+		if result.RTT <= 0 || result.RTT > time.Millisecond * 100 {
+			t.Errorf("port %d: got RTT %v", result.Port, result.RTT)
+		}
+	}
+}
+
+// TestScanRTTRetransmit checks that ports answering a resent probe get timed
+// from the resend rather than from the first probe.
+func TestScanRTTRetransmit(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+	answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {open: portOpen}})
+
+	// The first probe gets lost.
+	lost := false
+
+	link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+		if packets.has(layers.LayerTypeTCP) && !lost {
+			lost = true
+			return nil
+		}
+
+		return answer(data, packets)
+	})
+
+	link.delay = time.Millisecond * 2
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open}, fakeEthernet, link)
+	scanner.Retries = 1
+	scanner.RetransmitInterval = time.Millisecond * 150
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].State != Open {
+		t.Fatalf("got %+v, want the port to be open", results)
+	}
+
+	if rtt := results[0].RTT; rtt <= 0 || rtt >= scanner.RetransmitInterval {
+		t.Errorf("got RTT %v, want it timed from the resend", rtt)
+	}
+}
+
+// TestScanDistinctHosts scans several hosts at once, and checks that each one
+// of them gets probed, and gets the results of its own probes.
+func TestScanDistinctHosts(t *testing.T) {
+	ips, hosts := fakeHosts(5)
+
+	for _, ip := range ips {
+		hosts[ip.String()][2201] = portClosed
+	}
+
+	link := newFakeLink(answerPorts(t, hosts))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	wg := sync.WaitGroup{}
+
+	for _, ip := range ips {
+		wg.Add(1)
+
+		go func(ip net.IP) {
+			defer wg.Done()
+
+			scanner, err := New(ip, Options{Router: fakeRouter{}, Pool: pool, Ports: []uint16{2201}})
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			defer scanner.Close()
+
+			results, err := scanner.Scan(context.Background())
+
+			if err != nil || len(results) != 1 || results[0].IP != ip.String() || results[0].State != Closed {
+				t.Errorf("%s: got %+v, %v", ip, results, err)
+			}
+		}(ip)
+	}
+
+	wg.Wait()
+
+	probed := map[string]int{}
+
+	for _, probe := range link.sent(layers.LayerTypeTCP) {
+		probed[probe.ip4.DstIP.String()]++
+	}
+
+	for _, ip := range ips {
+		if probed[ip.String()] != 1 {
+			t.Errorf("%s: got %d probes, want 1", ip, probed[ip.String()])
+		}
+	}
+
+	if len(probed) != len(ips) {
+		t.Errorf("probed %v, want just %v", probed, ips)
+	}
+}
+
+func TestScanDestPort(t *testing.T) {
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2222: portClosed}}))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	scanner, err := New(net.IP{127, 0, 0, 2}, Options{Router: fakeRouter{}, Pool: pool, Ports: []uint16{2222}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer scanner.Close()
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil || len(results) != 1 || results[0].Port != 2222 || results[0].State != Closed {
+		t.Errorf("got %+v, %v, want port 2222 closed", results, err)
+	}
+
+	probes := link.sent(layers.LayerTypeTCP)
+
+	if len(probes) != 1 || probes[0].tcp.DstPort != 2222 || !probes[0].tcp.SYN {
+		t.Fatalf("got %d probes, want a SYN to port 2222", len(probes))
+	}
+}
+
+func TestSourcePorts(t *testing.T) {
+	// Every scanner picks a port of its own, out of the dynamic range.
+	picked := map[uint16]bool{}
+
+	for i := 0; i < 20; i++ {
+		port := RandomSourcePort()
+
+		if port < 49152 {
+			t.Errorf("got source port %d", port)
+		}
+
+		picked[port] = true
+	}
+
+	if len(picked) < 2 {
+		t.Errorf("got source ports %v, want different ones", picked)
+	}
+
+	// Every port of a scan gets probed from a source port of its own, which
+	// its reply comes back to.
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed, 2202: portClosed, 2203: portClosed}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201, 2202, 2203}, fakeEthernet, link)
+	scanner.SrcPort = 65534
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.State != Closed {
+			t.Errorf("port %d: got %v", result.Port, result.State)
+		}
+	}
+
+	// Source ports wrap around to the bottom of the range past the top.
+	want := map[layers.TCPPort]layers.TCPPort{2201: 65534, 2202: 65535, 2203: minSourcePort}
+
+	for _, probe := range link.sent(layers.LayerTypeTCP) {
+		if probe.tcp.SrcPort != want[probe.tcp.DstPort] {
+			t.Errorf("port %d: probed from %d, want %d", probe.tcp.DstPort, probe.tcp.SrcPort, want[probe.tcp.DstPort])
+		}
+	}
+}
+
+func TestScanShortTimeout(t *testing.T) {
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2203: portSilent}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2203}, fakeEthernet, link)
+	scanner.ScanTimeout = time.Millisecond * 50
+
+	start := time.Now()
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil || len(results) != 1 || results[0].State != Filtered {
+		t.Errorf("got %+v, %v, want the port filtered", results, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Millisecond * 500 {
+		t.Errorf("took %v with a timeout of %v", elapsed, scanner.ScanTimeout)
+	}
+
+	// Hosts that don't answer ARP give up as quickly.
+	scanner.DestIP = net.IP{127, 0, 0, 3}
+	scanner.ARPTimeout = time.Millisecond * 50
+	start = time.Now()
+
+	if _, err := scanner.Scan(context.Background()); err == nil {
+		t.Error("got no error for a host that doesn't answer ARP")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Millisecond * 500 {
+		t.Errorf("took %v with an ARP timeout of %v", elapsed, scanner.ARPTimeout)
+	}
+
+	// No timeout at all means the default one, not none.
+	if got := timeout(0); got != DefaultTimeout {
+		t.Errorf("got %v for no timeout, want %v", got, DefaultTimeout)
+	}
+
+	scanner.ScanTimeout = 0
+
+	if got := scanner.scanTimeout(); got != DefaultTimeout {
+		t.Errorf("got scan timeout %v, want %v", got, DefaultTimeout)
+	}
+}
+
+// TestProbeHeaders checks the headers of the probes that actually go out, as
+// the scanner is set up.
+func TestProbeHeaders(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		setup func(scanner *Scanner)
+		check func(probe *decoder) bool
+	}{
+		{"default ttl", func(scanner *Scanner) {}, func(probe *decoder) bool {
+			return probe.ip4.TTL == DefaultTTL
+		}},
+		{"ttl", func(scanner *Scanner) { scanner.TTL = 7 }, func(probe *decoder) bool {
+			return probe.ip4.TTL == 7
+		}},
+		{"ip id", func(scanner *Scanner) {
+			scanner.IPID = 4242
+			scanner.FixedIPID = true
+		}, func(probe *decoder) bool {
+			return probe.ip4.Id == 4242
+		}},
+		{"default window", func(scanner *Scanner) {}, func(probe *decoder) bool {
+			return probe.tcp.Window == DefaultWindow && len(probe.tcp.Options) == 0
+		}},
+		{"window and mss", func(scanner *Scanner) {
+			scanner.Window = 1024
+			scanner.MSS = 1460
+		}, func(probe *decoder) bool {
+			options := probe.tcp.Options
+
+			return probe.tcp.Window == 1024 && len(options) > 0 && options[0].OptionType == layers.TCPOptionKindMSS && options[0].OptionLength == 4 && bytes.Equal(options[0].OptionData, []byte{0x05, 0xb4})
+		}},
+		{"dont fragment", func(scanner *Scanner) {}, func(probe *decoder) bool {
+			return probe.ip4.Flags == layers.IPv4DontFragment
+		}},
+		{"allow fragments", func(scanner *Scanner) { scanner.AllowFragments = true }, func(probe *decoder) bool {
+			return probe.ip4.Flags == 0
+		}},
+	} {
+		link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}}))
+
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201}, fakeEthernet, link)
+		c.setup(scanner)
+
+		if _, err := scanner.Scan(context.Background()); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+
+		link.Close()
+		probes := link.sent(layers.LayerTypeTCP)
+
+		if len(probes) != 1 {
+			t.Errorf("%s: got %d probes", c.name, len(probes))
+			continue
+		}
+
+		if !c.check(probes[0]) {
+			t.Errorf("%s: got IPv4 %+v, TCP %+v", c.name, probes[0].ip4, probes[0].tcp)
+		}
+	}
+}
+
+// TestProbeFragments checks that fragmented probes have the TCP segment split
+// over fragments of the same IPv4 ID, in order, all of them but the last with
+// More Fragments set.
+func TestProbeFragments(t *testing.T) {
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201}, fakeEthernet, link)
+	scanner.ScanTimeout = time.Millisecond * 20
+	scanner.Fragment = true
+	scanner.AllowFragments = true
+
+	if _, err := scanner.Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	fragments := link.sent(layers.LayerTypeIPv4)
+
+	// A bare TCP header is 20 bytes, which takes three 8 byte fragments.
+	if len(fragments) != 3 {
+		t.Fatalf("got %d fragments, want 3", len(fragments))
+	}
+
+	for i, fragment := range fragments {
+		more := i < len(fragments) - 1
+
+		if fragment.ip4.FragOffset != uint16(i) || fragment.ip4.Id != fragments[0].ip4.Id || (fragment.ip4.Flags == layers.IPv4MoreFragments) != more {
+			t.Errorf("fragment %d: got offset %d, ID %d, flags %v", i, fragment.ip4.FragOffset, fragment.ip4.Id, fragment.ip4.Flags)
+		}
+	}
+}
+
+func TestScanTypes(t *testing.T) {
+	for _, c := range []struct {
+		scanType ScanType
+		flags func(tcp *layers.TCP) bool
+		silent PortState
+	}{
+		{SYNScan, func(tcp *layers.TCP) bool { return tcp.SYN && !tcp.FIN && !tcp.PSH && !tcp.URG }, Filtered},
+		{FINScan, func(tcp *layers.TCP) bool { return tcp.FIN && !tcp.SYN && !tcp.PSH && !tcp.URG }, OpenFiltered},
+		{NullScan, func(tcp *layers.TCP) bool { return !tcp.FIN && !tcp.SYN && !tcp.PSH && !tcp.URG && !tcp.ACK }, OpenFiltered},
+		{XmasScan, func(tcp *layers.TCP) bool { return tcp.FIN && tcp.PSH && tcp.URG && !tcp.SYN }, OpenFiltered},
+	} {
+		link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed, 2203: portSilent}}))
+
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201, 2203}, fakeEthernet, link)
+		scanner.ScanTimeout = time.Millisecond * 100
+		scanner.ScanType = c.scanType
+
+		results, err := scanner.Scan(context.Background())
+		link.Close()
+
+		if err != nil {
+			t.Errorf("%s: %v", c.scanType, err)
+			continue
+		}
+
+		// Closed ports answer with a RST, which acknowledges the probe,
+		// whatever the scan type.
+		want := map[uint16]PortState{2201: Closed, 2203: c.silent}
+
+		for _, result := range results {
+			if result.State != want[result.Port] {
+				t.Errorf("%s port %d: got %v (%s), want %v", c.scanType, result.Port, result.State, result.Reason, want[result.Port])
+			}
+		}
+
+		for _, probe := range link.sent(layers.LayerTypeTCP) {
+			if !c.flags(&probe.tcp) {
+				t.Errorf("%s: got a probe with the wrong flags: %+v", c.scanType, probe.tcp)
+			}
+		}
+	}
+}
+
+func TestScanReset(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+
+	for _, reset := range []bool{false, true} {
+		link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {open: portOpen}}))
+
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open}, fakeEthernet, link)
+		scanner.Reset = reset
+
+		results, err := scanner.Scan(context.Background())
+		link.Close()
+
+		if err != nil || len(results) != 1 || results[0].State != Open {
+			t.Errorf("reset %v: got %+v, %v, want the port open", reset, results, err)
+			continue
+		}
+
+		// The RST comes right after the SYN, and picks up where the SYN/ACK
+		// says the connection is at.
+		packets := link.sent(layers.LayerTypeTCP)
+
+		if !reset {
+			if len(packets) != 1 {
+				t.Errorf("got %d packets without reset, want just the probe", len(packets))
+			}
+
+			continue
+		}
+
+		if len(packets) != 2 {
+			t.Fatalf("got %d packets, want the probe and a reset", len(packets))
+		}
+
+		syn, rst := packets[0].tcp, packets[1].tcp
+
+		if !rst.RST || rst.SYN || rst.SrcPort != syn.SrcPort || rst.DstPort != syn.DstPort || rst.Seq != syn.Seq + 1 {
+			t.Errorf("got reset %+v after SYN %+v", rst, syn)
+		}
+	}
+}
+
+func TestScanFixedSourcePort(t *testing.T) {
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed, 2202: portClosed, 2203: portClosed}}))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	opts := Options{Router: fakeRouter{}, Pool: pool, Ports: []uint16{2201, 2202, 2203}, SourcePort: 40000}
+	scanner, err := New(net.IP{127, 0, 0, 2}, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer scanner.Close()
+
+	// The replies are all sent to the same port, and told apart by the port
+	// they come from.
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.State != Closed {
+			t.Errorf("port %d: got %v (%s), want closed", result.Port, result.State, result.Reason)
+		}
+	}
+
+	for _, probe := range link.sent(layers.LayerTypeTCP) {
+		if probe.tcp.SrcPort != 40000 {
+			t.Errorf("port %d: probed from %d, want 40000", probe.tcp.DstPort, probe.tcp.SrcPort)
+		}
+	}
+
+	// The BPF filter wouldn't let replies to ports below the range through.
+	opts.SourcePort = 1000
+
+	if _, err := New(net.IP{127, 0, 0, 2}, opts); err == nil {
+		t.Error("got no error for a source port below the range")
+	}
+}
+
+// TestScanStaleReplies checks that replies that don't acknowledge the probe of
+// their port, such as ones to an earlier scan from the same source port, are
+// ignored in favour of the one that does.
+func TestScanStaleReplies(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+	answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {}})
+
+	link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+		if !packets.has(layers.LayerTypeTCP) {
+			return answer(data, packets)
+		}
+
+		eth, ip4, probe := packets.eth, packets.ip4, packets.tcp
+		stale := probe
+		stale.Seq += 1000
+
+		synAck := func(tcp *layers.TCP) {
+			tcp.SYN = true
+			tcp.ACK = true
+		}
+
+		rst := func(tcp *layers.TCP) {
+			tcp.RST = true
+			tcp.ACK = true
+		}
+
+		// The stale reply comes first, then the actual one, then a duplicate
+		// of it.
+		if uint16(probe.DstPort) == open {
+			return [][]byte{tcpReply(t, &eth, &ip4, &stale, rst), tcpReply(t, &eth, &ip4, &probe, synAck), tcpReply(t, &eth, &ip4, &probe, synAck)}
+		}
+
+		return [][]byte{tcpReply(t, &eth, &ip4, &stale, synAck), tcpReply(t, &eth, &ip4, &probe, rst), tcpReply(t, &eth, &ip4, &probe, rst)}
+	})
+
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{open, 2201}, fakeEthernet, link)
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint16]PortState{open: Open, 2201: Closed}
+
+	for _, result := range results {
+		if result.State != want[result.Port] {
+			t.Errorf("port %d: got %v (%s), want %v", result.Port, result.State, result.Reason, want[result.Port])
+		}
+	}
+}
+
+// TestScanSequenceNumbers checks that every port is probed with a random
+// sequence number of its own, kept when the probe is sent again, and that a
+// reply acknowledging any other number is ignored.
+func TestScanSequenceNumbers(t *testing.T) {
+	answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {}})
+	ports := []uint16{2201, 2202, 2203, 2204, 2205}
+
+	var mutex sync.Mutex
+	seqs := map[layers.TCPPort][]uint32{}
+
+	link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+		if !packets.has(layers.LayerTypeTCP) {
+			return answer(data, packets)
+		}
+
+		eth, ip4, probe := packets.eth, packets.ip4, packets.tcp
+
+		mutex.Lock()
+		seqs[probe.DstPort] = append(seqs[probe.DstPort], probe.Seq)
+		first := len(seqs[probe.DstPort]) == 1
+		mutex.Unlock()
+
+		// The first probe gets a reset acknowledging one past the number, so
+		// that the port is only found closed once the probe is sent again.
+		if first {
+			probe.Seq++
+		}
+
+		return [][]byte{tcpReply(t, &eth, &ip4, &probe, func(tcp *layers.TCP) {
+			tcp.RST = true
+			tcp.ACK = true
+		})}
+	})
+
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, ports, fakeEthernet, link)
+	scanner.Retries = 1
+	scanner.RetransmitInterval = time.Millisecond * 50
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.State != Closed {
+			t.Errorf("port %d: got %v (%s), want closed", result.Port, result.State, result.Reason)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	distinct := map[uint32]bool{}
+
+	for port, sent := range seqs {
+		if len(sent) != 2 {
+			t.Errorf("port %d: got %d probes, want 2", port, len(sent))
+			continue
+		}
+
+		if sent[0] != sent[1] {
+			t.Errorf("port %d: sent again with %d, want %d", port, sent[1], sent[0])
+		}
+
+		distinct[sent[0]] = true
+	}
+
+	if len(distinct) != len(ports) {
+		t.Errorf("got %d distinct sequence numbers for %d ports", len(distinct), len(ports))
+	}
+}
+
+func TestScanTarpit(t *testing.T) {
+	tarpit := listenSSH(t, "127.0.0.2")
+	server := listenSSH(t, "127.0.0.2")
+	answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {}})
+
+	// The tarpit advertises no window at all, the server a usual one.
+	link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+		if !packets.has(layers.LayerTypeTCP) {
+			return answer(data, packets)
+		}
+
+		eth, ip4, probe := packets.eth, packets.ip4, packets.tcp
+
+		return [][]byte{tcpReply(t, &eth, &ip4, &probe, func(tcp *layers.TCP) {
+			tcp.SYN = true
+			tcp.ACK = true
+
+			if uint16(probe.DstPort) == tarpit {
+				tcp.Window = 0
+			}
+		})}
+	})
+
+	defer link.Close()
+
+	for _, skip := range []bool{false, true} {
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{tarpit, server}, fakeEthernet, link)
+		scanner.SkipTarpits = skip
+
+		results, err := scanner.Scan(context.Background())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, result := range results {
+			if result.State != Open {
+				t.Errorf("port %d: got %v (%s), want open", result.Port, result.State, result.Reason)
+			}
+
+			if result.Tarpit != (result.Port == tarpit) {
+				t.Errorf("port %d: got tarpit %v", result.Port, result.Tarpit)
+			}
+
+			// Only the banner of the tarpit is skipped, and only when told to.
+			if grabbed := result.Banner != ""; grabbed != (result.Port == server || !skip) {
+				t.Errorf("port %d, skipping tarpits %v: got banner %q", result.Port, skip, result.Banner)
+			}
+		}
+	}
+}
+
+func TestDestMACAddressFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		hosts []string
+		asked []string
+		err bool
+	}{
+		{"gateway answers", []string{"127.0.0.2", "127.0.0.254"}, []string{"127.0.0.254"}, false},
+		{"host answers", []string{"127.0.0.2"}, []string{"127.0.0.254", "127.0.0.2"}, false},
+		{"neither answers", nil, []string{"127.0.0.254", "127.0.0.2"}, true},
+	}
+
+	for _, test := range tests {
+		hosts := map[string]map[uint16]string{}
+
+		for _, host := range test.hosts {
+			hosts[host] = map[uint16]string{}
+		}
+
+		link := newFakeLink(answerPorts(t, hosts))
+
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{22}, fakeEthernet, link)
+		scanner.Gateway = net.IP{127, 0, 0, 254}
+		scanner.ARPTimeout = time.Millisecond * 100
+
+		hwaddr, err := scanner.DestMACAddress(context.Background())
+		link.Close()
+
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v", test.name, err)
+		} else if err == nil && !bytes.Equal(hwaddr, fakeHostMAC) {
+			t.Errorf("%s: got %v, want %v", test.name, hwaddr, fakeHostMAC)
+		}
+
+		// The host itself is only asked about once its gateway didn't answer.
+		var asked []string
+
+		for _, request := range link.sent(layers.LayerTypeARP) {
+			target := net.IP(request.arp.DstProtAddress).String()
+
+			if len(asked) == 0 || asked[len(asked) - 1] != target {
+				asked = append(asked, target)
+			}
+		}
+
+		if strings.Join(asked, " ") != strings.Join(test.asked, " ") {
+			t.Errorf("%s: asked about %v, want %v", test.name, asked, test.asked)
+		}
+	}
+}
+
+// TestScanResetReply checks that a port the host answers with a RST is reported as
+// closed as soon as the RST shows up, rather than once the scan times out.
+func TestScanResetReply(t *testing.T) {
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201}, fakeEthernet, link)
+	scanner.ScanTimeout = time.Second * 5
+
+	start := time.Now()
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].State != Closed || results[0].Reason != "reset" {
+		t.Fatalf("got %+v, want port 2201 closed by a reset", results)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v, want the scan to end on the RST", elapsed)
+	}
+}
+
+func TestARPIgnoresNonReplies(t *testing.T) {
+	otherMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x03}
+
+	arpPacket := func(operation uint16, hwaddr net.HardwareAddr, request *layers.ARP) []byte {
+		return serialize(t,
+			&layers.Ethernet{SrcMAC: otherMAC, DstMAC: fakeEthernet.HardwareAddr, EthernetType: layers.EthernetTypeARP},
+			&layers.ARP{
+				AddrType: layers.LinkTypeEthernet,
+				Protocol: layers.EthernetTypeIPv4,
+				HwAddressSize: 6,
+				ProtAddressSize: 4,
+				Operation: operation,
+				SourceHwAddress: hwaddr,
+				SourceProtAddress: request.DstProtAddress,
+				DstHwAddress: request.SourceHwAddress,
+				DstProtAddress: request.SourceProtAddress,
+			})
+	}
+
+	// The host asks about us and answers without saying where it is before it
+	// actually answers.
+	answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {}})
+
+	link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+		request := packets.arp
+
+		return append([][]byte{
+			arpPacket(layers.ARPRequest, otherMAC, &request),
+			arpPacket(layers.ARPReply, net.HardwareAddr{0, 0, 0, 0, 0, 0}, &request),
+		}, answer(data, packets)...)
+	})
+
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{22}, fakeEthernet, link)
+	hwaddr, err := scanner.ARPMACAddress(context.Background(), scanner.DestIP)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(hwaddr, fakeHostMAC) {
+		t.Errorf("got %v, want %v", hwaddr, fakeHostMAC)
+	}
+}
+
+func TestScanVLAN(t *testing.T) {
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201}, fakeEthernet, link)
+	scanner.VLAN = 100
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].State != Closed {
+		t.Errorf("got %v (%s), want closed", results[0].State, results[0].Reason)
+	}
+
+	// Both the ARP request and the probe are tagged, with the type of what
+	// they carry moved into the tag.
+	want := map[layers.EthernetType]bool{layers.EthernetTypeARP: false, layers.EthernetTypeIPv4: false}
+	tagged := link.sent(layers.LayerTypeDot1Q)
+
+	if len(tagged) != len(link.sent(layers.LayerTypeEthernet)) {
+		t.Errorf("got %d tagged frames out of %d", len(tagged), len(link.sent(layers.LayerTypeEthernet)))
+	}
+
+	for _, frame := range tagged {
+		if frame.eth.EthernetType != layers.EthernetTypeDot1Q || frame.dot1q.VLANIdentifier != 100 {
+			t.Errorf("got type %v, VLAN %d, want 802.1Q, VLAN 100", frame.eth.EthernetType, frame.dot1q.VLANIdentifier)
+		}
+
+		want[frame.dot1q.Type] = true
+	}
+
+	for ethernetType, seen := range want {
+		if !seen {
+			t.Errorf("no tagged %v frame sent", ethernetType)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	hosts := map[string]map[uint16]string{"127.0.0.2": {2201: portClosed, 2202: portClosed}}
+
+	// The host doesn't answer ARP, which fails every port.
+	link := newFakeLink(answerPorts(t, hosts))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 3}, []uint16{2201, 2202}, fakeEthernet, link)
+	scanner.ARPTimeout = time.Millisecond * 50
+
+	results, err := scanner.Scan(context.Background())
+
+	if !errors.Is(err, ErrNoNeighbor) {
+		t.Errorf("got error %v for a host that doesn't answer ARP, want %v", err, ErrNoNeighbor)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	for _, result := range results {
+		if result.State != Filtered || result.Reason != "arp-timeout" || result.Error != err.Error() {
+			t.Errorf("port %d: got %v (%s), error %q, want filtered (arp-timeout), error %q", result.Port, result.State, result.Reason, result.Error, err)
+		}
+	}
+
+	// The probe of one of the ports doesn't go out, which fails only that
+	// port. It's never answered, so it's filtered.
+	failing := &failingLink{fakeLink: newFakeLink(answerPorts(t, hosts)), layerType: layers.LayerTypeTCP, err: errors.New("Network is down"), failures: 1}
+	defer failing.Close()
+
+	scanner = newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201, 2202}, fakeEthernet, failing)
+	results, err = scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := 0
+
+	for _, result := range results {
+		switch {
+		case result.Error == "":
+			if result.State != Closed {
+				t.Errorf("port %d: got %v (%s), want closed", result.Port, result.State, result.Reason)
+			}
+		case result.Error == "Network is down" && result.State == Filtered:
+			failed++
+		default:
+			t.Errorf("port %d: got %v (%s), error %q", result.Port, result.State, result.Reason, result.Error)
+		}
+	}
+
+	if failed != 1 {
+		t.Errorf("got %d ports failing to send, want 1", failed)
+	}
+
+	// The ARP request can't be sent at all, which fails every port as well,
+	// but not for the host not answering.
+	unsent := &failingLink{fakeLink: newFakeLink(answerPorts(t, hosts)), layerType: layers.LayerTypeARP, err: syscall.ENETDOWN, failures: 10}
+	defer unsent.Close()
+
+	scanner = newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201, 2202}, fakeEthernet, unsent)
+	results, err = scanner.Scan(context.Background())
+
+	if !errors.Is(err, syscall.ENETDOWN) || errors.Is(err, ErrNoNeighbor) {
+		t.Errorf("got error %v, want %v", err, syscall.ENETDOWN)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	for _, result := range results {
+		if result.State != Filtered || result.Reason != "error" || result.Error != err.Error() {
+			t.Errorf("port %d: got %v (%s), error %q, want filtered (error), error %q", result.Port, result.State, result.Reason, result.Error, err)
+		}
+	}
+}
+
+func TestSendPacketBufferFull(t *testing.T) {
+	hosts := map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}}
+
+	tests := []struct {
+		name string
+		err error
+		failures int
+		attempts int
+		sent bool
+	}{
+		{"full once", syscall.ENOBUFS, 1, 2, true},
+		{"full in libpcap", errors.New("send: No buffer space available"), 1, 2, true},
+		{"stays full", syscall.ENOBUFS, maxSendRetries + 1, maxSendRetries + 1, false},
+		{"other error", syscall.ENETDOWN, 1, 1, false},
+	}
+
+	for _, test := range tests {
+		link := &failingLink{fakeLink: newFakeLink(answerPorts(t, hosts)), layerType: layers.LayerTypeTCP, err: test.err, failures: test.failures}
+
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201}, fakeEthernet, link)
+		results, err := scanner.Scan(context.Background())
+		link.Close()
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		// Only a full buffer is worth sending the probe again for.
+		if link.attempts != test.attempts {
+			t.Errorf("%s: got %d attempts, want %d", test.name, link.attempts, test.attempts)
+		}
+
+		if sent := results[0].Error == ""; sent != test.sent {
+			t.Errorf("%s: got error %q", test.name, results[0].Error)
+		}
+
+		if test.sent && results[0].State != Closed {
+			t.Errorf("%s: got %v (%s), want closed", test.name, results[0].State, results[0].Reason)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		max time.Duration
+		sends int
+		want time.Duration
+	}{
+		{0, 0, 1, DefaultRetransmit},
+		{time.Millisecond * 100, 0, 1, time.Millisecond * 100},
+		{time.Millisecond * 100, 0, 2, time.Millisecond * 200},
+		{time.Millisecond * 100, 0, 4, time.Millisecond * 800},
+		{time.Millisecond * 100, time.Millisecond * 500, 4, time.Millisecond * 500},
+		{time.Second, 0, 10, DefaultMaxBackoff},
+		{time.Second, 0, 1000, DefaultMaxBackoff},
+	}
+
+	for _, test := range tests {
+		scanner := &Scanner{RetransmitInterval: test.interval, MaxBackoff: test.max}
+		low, high := test.want * 3 / 4, test.want * 5 / 4
+
+		// The jitter is random, so enough waits are drawn to see both ends
+		// of it.
+		min, max := high, low
+
+		for i := 0; i < 1000; i++ {
+			wait := scanner.backoff(test.sends)
+
+			if wait < low || wait > high {
+				t.Fatalf("%v up to %v, %d sends: got %v, want between %v and %v", test.interval, test.max, test.sends, wait, low, high)
+			}
+
+			if wait < min {
+				min = wait
+			}
+
+			if wait > max {
+				max = wait
+			}
+		}
+
+		if spread := test.want / 4; max - min < spread {
+			t.Errorf("%v up to %v, %d sends: waits only spread between %v and %v", test.interval, test.max, test.sends, min, max)
+		}
+	}
+}
+
+func TestScanRateLimit(t *testing.T) {
+	ports := []uint16{2201, 2202, 2203, 2204, 2205, 2206, 2207, 2208, 2209}
+	closed := map[uint16]string{}
+
+	for _, port := range ports {
+		closed[port] = portClosed
+	}
+
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": closed}))
+	defer link.Close()
+
+	// 50 packets a second, with no burst: the ARP request and the probes go
+	// out at least 20ms apart.
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, ports, fakeEthernet, link)
+	scanner.Limiter = rate.NewLimiter(50, 1)
+	scanner.ScanTimeout = time.Second
+
+	if _, err := scanner.Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+
+	if len(link.times) != len(ports) + 1 {
+		t.Fatalf("got %d packets, want %d", len(link.times), len(ports) + 1)
+	}
+
+	// The limiter may let a packet out a little early, as long as the rate
+	// holds over the whole scan.
+	for i := 1; i < len(link.times); i++ {
+		if gap := link.times[i].Sub(link.times[i - 1]); gap < time.Millisecond * 15 {
+			t.Errorf("packet %d sent %v after the one before, want about 20ms", i, gap)
+		}
+	}
+
+	if elapsed := link.times[len(link.times) - 1].Sub(link.times[0]); elapsed < time.Millisecond * 180 {
+		t.Errorf("sent %d packets in %v, want at least 180ms at 50 a second", len(link.times), elapsed)
+	}
+}
+
+// echoReply : Builds the reply of a host to an ICMP echo request.
+func echoReply(t testing.TB, packets *decoder) []byte {
+	eth, ip4, echo := packets.eth, packets.ip4, packets.icmp4
+
+	return serialize(t,
+		&layers.Ethernet{SrcMAC: eth.DstMAC, DstMAC: eth.SrcMAC, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{SrcIP: ip4.DstIP, DstIP: ip4.SrcIP, Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4},
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0), Id: echo.Id, Seq: echo.Seq})
+}
+
+func TestScanPing(t *testing.T) {
+	hosts := map[string]map[uint16]string{
+		"127.0.0.2": {2201: portClosed},
+		"127.0.0.3": {2201: portClosed},
+		"127.0.0.254": {},
+	}
+
+	tests := []struct {
+		name string
+		ip net.IP
+		gateway net.IP
+		pinged bool
+		err error
+	}{
+		{"up behind the gateway", net.IP{127, 0, 0, 2}, net.IP{127, 0, 0, 254}, true, nil},
+		{"down behind the gateway", net.IP{127, 0, 0, 3}, net.IP{127, 0, 0, 254}, true, ErrHostDown},
+		{"on the link", net.IP{127, 0, 0, 3}, nil, false, nil},
+	}
+
+	for _, test := range tests {
+		// Only 127.0.0.2 answers pings, but both answer probes.
+		answer := answerPorts(t, hosts)
+
+		link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+			if packets.has(layers.LayerTypeICMPv4) {
+				if packets.ip4.DstIP.Equal(net.IP{127, 0, 0, 2}) && packets.icmp4.TypeCode.Type() == layers.ICMPv4TypeEchoRequest {
+					return [][]byte{echoReply(t, packets)}
+				}
+
+				return nil
+			}
+
+			return answer(data, packets)
+		})
+
+		scanner := newTestScanner(test.ip, []uint16{2201}, fakeEthernet, link)
+		scanner.Gateway = test.gateway
+		scanner.Ping = true
+
+		results, err := scanner.Scan(context.Background())
+		link.Close()
+
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+
+		// Hosts that are down get neither results nor probes.
+		if test.err != nil {
+			if len(results) != 0 || len(link.sent(layers.LayerTypeTCP)) != 0 {
+				t.Errorf("%s: got %+v, %d probes sent", test.name, results, len(link.sent(layers.LayerTypeTCP)))
+			}
+		} else if len(results) != 1 || results[0].State != Closed {
+			t.Errorf("%s: got %+v, want the port closed", test.name, results)
+		}
+
+		// Hosts on the link answered ARP already.
+		if pinged := len(link.sent(layers.LayerTypeICMPv4)) > 0; pinged != test.pinged {
+			t.Errorf("%s: got pinged %v, want %v", test.name, pinged, test.pinged)
+		}
+	}
+}
+
+func TestScanSkipDiscovery(t *testing.T) {
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	tests := []struct {
+		name string
+		gateway net.IP
+		skip bool
+		probed bool
+	}{
+		{"on the link", nil, true, true},
+		{"on the link, without -Pn", nil, false, false},
+		{"behind the gateway", net.IP{127, 0, 0, 254}, true, false},
+	}
+
+	for _, test := range tests {
+		// The host drops ARP and pings, but still answers probes.
+		answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}})
+
+		link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+			if packets.has(layers.LayerTypeARP) || packets.has(layers.LayerTypeICMPv4) {
+				return nil
+			}
+
+			return answer(data, packets)
+		})
+
+		scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{2201}, fakeEthernet, link)
+		scanner.Gateway = test.gateway
+		scanner.SkipDiscovery = test.skip
+		scanner.Ping = true
+		scanner.ARPTimeout = time.Millisecond * 50
+
+		results, err := scanner.Scan(context.Background())
+		link.Close()
+
+		probes := link.sent(layers.LayerTypeTCP)
+
+		if !test.probed {
+			if err == nil || len(probes) != 0 {
+				t.Errorf("%s: got error %v, %d probes sent, want the host failed", test.name, err, len(probes))
+			}
+
+			continue
+		}
+
+		if err != nil || len(results) != 1 || results[0].State != Closed {
+			t.Errorf("%s: got %+v, %v, want the port closed", test.name, results, err)
+		}
+
+		// With nowhere else to send them, the probes go to every host on the
+		// link, and no ping goes out.
+		for _, probe := range probes {
+			if !bytes.Equal(probe.eth.DstMAC, broadcast) {
+				t.Errorf("%s: probe sent to %v, want %v", test.name, probe.eth.DstMAC, broadcast)
+			}
+		}
+
+		if len(link.sent(layers.LayerTypeICMPv4)) != 0 {
+			t.Errorf("%s: got a ping sent", test.name)
+		}
+	}
+}
+
+// TestScanDialFailed checks that ports that answer with a SYN/ACK but don't
+// take a connection are told apart from open ones.
+func TestScanDialFailed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {port: portOpen}}))
+	defer link.Close()
+
+	scanner := newTestScanner(net.IP{127, 0, 0, 2}, []uint16{port}, fakeEthernet, link)
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].State != DialFailed || results[0].Reason != "syn-ack" || results[0].Banner != "" {
+		t.Errorf("got %v (%s), banner %q, want dial-failed", results[0].State, results[0].Reason, results[0].Banner)
+	}
+}
+
+func TestNoChecksums(t *testing.T) {
+	for _, noChecksums := range []bool{false, true} {
+		link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}}))
+		opened := 0
+
+		pool := newTestPool(link, &opened)
+
+		opts := Options{Router: fakeRouter{}, Pool: pool, Ports: []uint16{2201}, NoChecksums: noChecksums}
+		scanner, err := New(net.IP{127, 0, 0, 2}, opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if scanner.SerializeOptions.ComputeChecksums == noChecksums || !scanner.SerializeOptions.FixLengths {
+			t.Errorf("without checksums %v: got %+v", noChecksums, scanner.SerializeOptions)
+		}
+
+		if _, err := scanner.Scan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		scanner.Close()
+		pool.Close()
+
+		// Checksums left for the NIC to compute are zero on the way to it.
+		for _, probe := range link.sent(layers.LayerTypeTCP) {
+			if zero := probe.ip4.Checksum == 0 && probe.tcp.Checksum == 0; zero != noChecksums {
+				t.Errorf("without checksums %v: got IPv4 checksum %#x, TCP checksum %#x", noChecksums, probe.ip4.Checksum, probe.tcp.Checksum)
+			}
+		}
+	}
+}
+
+func TestScanSourceIP(t *testing.T) {
+	spoofed := net.IP{127, 0, 0, 9}
+
+	for _, toSource := range []bool{true, false} {
+		answer := answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {2201: portClosed}})
+
+		// The host answers the address the probe came from, or the one of the
+		// interface, which isn't what the probe came from.
+		link := newFakeLink(func(data []byte, packets *decoder) [][]byte {
+			if !toSource && packets.has(layers.LayerTypeTCP) {
+				packets.ip4.SrcIP = net.IP{127, 0, 0, 1}
+			}
+
+			return answer(data, packets)
+		})
+
+		opened := 0
+		pool := newTestPool(link, &opened)
+
+		opts := Options{Router: fakeRouter{}, Pool: pool, Ports: []uint16{2201}, SourceIP: spoofed, ScanTimeout: time.Millisecond * 300}
+		scanner, err := New(net.IP{127, 0, 0, 2}, opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := scanner.Scan(context.Background())
+		scanner.Close()
+		pool.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := Closed
+
+		if !toSource {
+			want = Filtered
+		}
+
+		if results[0].State != want {
+			t.Errorf("replying to the source %v: got %v (%s), want %v", toSource, results[0].State, results[0].Reason, want)
+		}
+
+		for _, probe := range link.sent(layers.LayerTypeTCP) {
+			if !probe.ip4.SrcIP.Equal(spoofed) {
+				t.Errorf("probe sent from %v, want %v", probe.ip4.SrcIP, spoofed)
+			}
+		}
+	}
+
+	// The source has to be of the same family as the target.
+	opts := Options{Router: fakeRouter{}, Pool: NewPool(), Ports: []uint16{2201}, SourceIP: net.ParseIP("fd00::9")}
+
+	if _, err := New(net.IP{127, 0, 0, 2}, opts); err == nil {
+		t.Error("got no error for an IPv6 source of an IPv4 target")
+	}
+}
+
+// loopbackRouter routes every host through a loopback interface.
+type loopbackRouter struct{}
+
+// fakeLoopback is the interface of loopbackRouter, which has no hardware
+// address, like lo.
+var fakeLoopback = &net.Interface{Name: "lo", MTU: 65536, Flags: net.FlagUp | net.FlagLoopback}
+
+// Route : Routes a host through fakeLoopback.
+func (router loopbackRouter) Route(dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return fakeLoopback, nil, net.IP{127, 0, 0, 1}, nil
+}
+
+// RouteWithSrc : Routes a host through fakeLoopback, whatever the source.
+func (router loopbackRouter) RouteWithSrc(input net.HardwareAddr, src, dst net.IP) (*net.Interface, net.IP, net.IP, error) {
+	return router.Route(dst)
+}
+
+func TestScanLoopback(t *testing.T) {
+	open := listenSSH(t, "127.0.0.2")
+	link := newFakeLink(answerPorts(t, map[string]map[uint16]string{"127.0.0.2": {open: portOpen, 2201: portClosed}}))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	// Loopback interfaces have no hardware address, but still get raw
+	// packets rather than falling back to connecting.
+	scanner, err := New(net.IP{127, 0, 0, 2}, Options{Router: loopbackRouter{}, Pool: pool, Ports: []uint16{open, 2201}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer scanner.Close()
+
+	if scanner.Connect {
+		t.Fatal("connecting instead of sending raw packets on loopback")
+	}
+
+	results, err := scanner.Scan(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint16]PortState{open: Open, 2201: Closed}
+
+	for _, result := range results {
+		if result.State != want[result.Port] {
+			t.Errorf("port %d: got %v (%s), want %v", result.Port, result.State, result.Reason, want[result.Port])
+		}
+	}
+
+	// Nothing answers ARP there, so none is sent, and every address is zero.
+	if arps := link.sent(layers.LayerTypeARP); len(arps) != 0 {
+		t.Errorf("got %d ARP requests sent", len(arps))
+	}
+
+	zero := make(net.HardwareAddr, 6)
+
+	for _, probe := range link.sent(layers.LayerTypeTCP) {
+		if !bytes.Equal(probe.eth.SrcMAC, zero) || !bytes.Equal(probe.eth.DstMAC, zero) {
+			t.Errorf("probe sent from %v to %v, want zero addresses", probe.eth.SrcMAC, probe.eth.DstMAC)
+		}
+	}
+}
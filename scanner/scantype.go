@@ -0,0 +1,89 @@
+package scanner
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+)
+
+// ScanType is the kind of probe sent to the ports.
+type ScanType int
+
+const (
+	// SYNScan sends a SYN, which open ports answer with a SYN/ACK and closed
+	// ones with a RST.
+	SYNScan ScanType = iota
+
+	// FINScan, NullScan and XmasScan send a packet with just FIN, no flags or
+	// FIN, PSH and URG set respectively. Closed ports answer those with a RST
+	// while open ones stay quiet, as do filtered ones.
+	FINScan
+	NullScan
+	XmasScan
+)
+
+// ParseScanType : Parses the name of a scan type, such as "syn" or "xmas".
+func ParseScanType(name string) (ScanType, error) {
+	switch name {
+	case "syn":
+		return SYNScan, nil
+	case "fin":
+		return FINScan, nil
+	case "null":
+		return NullScan, nil
+	case "xmas":
+		return XmasScan, nil
+	}
+
+	return SYNScan, fmt.Errorf("Unknown scan type: %q", name)
+}
+
+// String : Returns the name of the scan type.
+func (scanType ScanType) String() string {
+	switch scanType {
+	case FINScan:
+		return "fin"
+	case NullScan:
+		return "null"
+	case XmasScan:
+		return "xmas"
+	default:
+		return "syn"
+	}
+}
+
+// setFlags : Sets the TCP flags of a probe of this type.
+func (scanType ScanType) setFlags(tcp *layers.TCP) {
+	switch scanType {
+	case FINScan:
+		tcp.FIN = true
+	case NullScan:
+	case XmasScan:
+		tcp.FIN = true
+		tcp.PSH = true
+		tcp.URG = true
+	default:
+		tcp.SYN = true
+	}
+}
+
+// acked : Returns the acknowledgment number replies to a probe of this type
+// sent with the given sequence number have, which is one more for the SYN or
+// FIN flag of the probe, if it has one.
+func (scanType ScanType) acked(seq uint32) uint32 {
+	if scanType == NullScan {
+		return seq
+	}
+
+	return seq + 1
+}
+
+// Silent : Returns the state of ports that never answer a probe of this type.
+func (scanType ScanType) Silent() PortState {
+	if scanType == SYNScan {
+		return Filtered
+	}
+
+	// Open ports don't answer these probes, so silence may mean either.
+	return OpenFiltered
+}
@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Stream : Scans every target coming out of the targets channel with the given
+// number of workers, and delivers the results over the returned channel as
+// soon as each host is done, so callers can handle them while the scan goes
+// on. The channel is closed exactly once, after the targets channel has been
+// closed and all the workers are done. Once the context is cancelled, the
+// remaining targets are drained without being scanned. Hosts that couldn't be
+// scanned come with the Error of their results set, or are skipped if not
+// even a scanner could be created for them.
+//
+// Set a Router and Pool in the options so that the workers share them.
+func Stream(ctx context.Context, targets <-chan net.IP, opts Options, workers int) <-chan Result {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make(chan Result, workers)
+
+	// A wait group that will help us wait until all the workers are done.
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ip := range targets {
+				// Skip whatever is left in the queue once the scan has been
+				// cancelled.
+				if ctx.Err() != nil {
+					continue
+				}
+
+				for _, result := range scanHost(ctx, ip, opts) {
+					select {
+					case results <- result:
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+
+	// Only close the channel once nobody can write to it anymore.
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// scanHost : Creates a scanner for a single IP, runs it and cleans up.
+func scanHost(ctx context.Context, ip net.IP, opts Options) []Result {
+	scanner, err := New(ip, opts)
+
+	if err != nil {
+		loggerOrDiscard(opts.Logger).Warn("Unable to create scanner", "ip", ip, "err", err)
+		return nil
+	}
+
+	defer scanner.Close()
+
+	results, err := scanner.Scan(ctx)
+
+	if err != nil {
+		scanner.logger().Debug("Unable to scan", "ip", ip, "err", err)
+	}
+
+	return results
+}
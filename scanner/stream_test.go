@@ -0,0 +1,102 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	ips, hosts := fakeHosts(6)
+
+	for _, ports := range hosts {
+		ports[2201] = portClosed
+		ports[2202] = portClosed
+	}
+
+	// One host doesn't answer ARP, and still gets its results.
+	quiet := net.IP{127, 0, 1, 99}
+	ips = append(ips, quiet)
+
+	link := newFakeLink(answerPorts(t, hosts))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	opts := Options{
+		Router: fakeRouter{},
+		Pool: pool,
+		Ports: []uint16{2201, 2202},
+		ARPTimeout: time.Millisecond * 100,
+		ScanTimeout: time.Millisecond * 300,
+	}
+
+	// More hosts than workers, and results read slower than the buffer of
+	// the channel fills up.
+	seen := map[string]int{}
+
+	for result := range Stream(context.Background(), feedHosts(ips), opts, 3) {
+		seen[result.IP]++
+
+		if result.IP == quiet.String() {
+			if result.Error == "" {
+				t.Errorf("%s port %d: got no error for a host that doesn't answer ARP", result.IP, result.Port)
+			}
+		} else if result.State != Closed {
+			t.Errorf("%s port %d: got %v (%s), want closed", result.IP, result.Port, result.State, result.Reason)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if len(seen) != len(ips) {
+		t.Errorf("got results for %d hosts, want %d", len(seen), len(ips))
+	}
+
+	for ip, n := range seen {
+		if n != 2 {
+			t.Errorf("%s: got %d results, want 2", ip, n)
+		}
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	ips, hosts := fakeHosts(50)
+	link := newFakeLink(answerPorts(t, hosts))
+	opened := 0
+
+	pool := newTestPool(link, &opened)
+	defer pool.Close()
+
+	opts := Options{Router: fakeRouter{}, Pool: pool, Ports: []uint16{2201}, ScanTimeout: time.Millisecond * 100}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Once cancelled, the rest of the targets are drained without being
+	// scanned, and the channel is closed.
+	results := Stream(ctx, feedHosts(ips), opts, 2)
+	<-results
+	cancel()
+
+	scanned := 1
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for range results {
+			scanned++
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("the results channel wasn't closed after cancelling")
+	}
+
+	if scanned >= len(ips) {
+		t.Errorf("got results for all %d hosts after cancelling", scanned)
+	}
+}
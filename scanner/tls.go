@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+)
+
+// tlsPorts are the ports services usually speak TLS on from the start, whose
+// banners come from a TLS handshake rather than a line of text.
+var tlsPorts = map[uint16]bool{
+	443: true,
+	465: true,
+	636: true,
+	853: true,
+	990: true,
+	992: true,
+	993: true,
+	995: true,
+	8443: true,
+}
+
+// TLSInfo is what a TLS handshake with a port told about it.
+type TLSInfo struct {
+	// The TLS version and cipher suite the server picked, such as "TLS 1.3"
+	// and "TLS_AES_128_GCM_SHA256".
+	Version string `json:"version"`
+	Cipher string `json:"cipher"`
+
+	// The subject of the server's certificate, and the names and addresses
+	// it's valid for.
+	Subject string `json:"subject,omitempty"`
+	SANs []string `json:"sans,omitempty"`
+}
+
+// String : Sums up the handshake on a single line, to use as the banner.
+func (info *TLSInfo) String() string {
+	parts := []string{info.Version, info.Cipher}
+
+	if info.Subject != "" {
+		parts = append(parts, info.Subject)
+	}
+
+	if len(info.SANs) > 0 {
+		parts = append(parts, strings.Join(info.SANs, " "))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// readTLS : Does a TLS handshake over conn and returns what it told us, along
+// with the connection to carry on over. The certificate isn't verified, since
+// we only want to know what it says.
+func readTLS(conn net.Conn) (*tls.Conn, *TLSInfo, error) {
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion: tls.VersionTLS10,
+	})
+
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	info := &TLSInfo{
+		Version: tls.VersionName(state.Version),
+		Cipher: tls.CipherSuiteName(state.CipherSuite),
+	}
+
+	// The first certificate is the server's own, the rest are the chain.
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		info.Subject = cert.Subject.String()
+
+		for _, name := range cert.DNSNames {
+			info.SANs = append(info.SANs, "DNS:" + name)
+		}
+
+		for _, ip := range cert.IPAddresses {
+			info.SANs = append(info.SANs, "IP:" + ip.String())
+		}
+	}
+
+	return tlsConn, info, nil
+}
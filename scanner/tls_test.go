@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestTLSBanner(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	addr := server.Listener.Addr().(*net.TCPAddr)
+
+	scanner := newTestScanner(addr.IP, []uint16{uint16(addr.Port)}, fakeEthernet, nil)
+	scanner.TLS = true
+
+	banner, info, err := scanner.banner(context.Background(), uint16(addr.Port))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info == nil {
+		t.Fatalf("got banner %q without the handshake", banner)
+	}
+
+	// The certificate of httptest is for example.com and the loopback
+	// addresses, and issued to Acme Co.
+	if info.Version != "TLS 1.3" || info.Cipher == "" || info.Subject != "O=Acme Co" || !slices.Contains(info.SANs, "DNS:example.com") || !slices.Contains(info.SANs, "IP:127.0.0.1") {
+		t.Errorf("got %+v", info)
+	}
+
+	if banner != info.String() {
+		t.Errorf("got banner %q, want %q", banner, info.String())
+	}
+
+	// Services that don't speak TLS fail the handshake.
+	port := listenSSH(t, "127.0.0.2")
+	scanner.DestIP = net.IP{127, 0, 0, 2}
+
+	if banner, info, err := scanner.banner(context.Background(), port); err != nil || info != nil || banner != "Unable to complete TLS handshake" {
+		t.Errorf("got %q, %+v, %v", banner, info, err)
+	}
+}
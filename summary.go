@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+// Summary keeps the totals of a scan as the results come in.
+type Summary struct {
+	// When the scan started.
+	Start time.Time
+
+	// How many hosts were scanned, how many of them couldn't be, and how many
+	// were skipped for being down.
+	Hosts int
+	Failed int
+	Down int
+
+	// How many ports ended up in each state.
+	Open int
+	Closed int
+	Filtered int
+
+	mutex sync.Mutex
+}
+
+// NewSummary : Creates a summary for a scan starting now.
+func NewSummary() *Summary {
+	return &Summary{Start: time.Now()}
+}
+
+// Add : Counts the results of a host.
+func (summary *Summary) Add(results []scanner.Result) {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.Hosts++
+
+	for _, result := range results {
+		switch result.State {
+		case scanner.Open, scanner.DialFailed:
+			summary.Open++
+		case scanner.Closed:
+			summary.Closed++
+		default:
+			summary.Filtered++
+		}
+	}
+}
+
+// Fail : Counts a host that couldn't be scanned.
+func (summary *Summary) Fail() {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.Hosts++
+	summary.Failed++
+}
+
+// Skip : Counts a host that was skipped for being down.
+func (summary *Summary) Skip() {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	summary.Hosts++
+	summary.Down++
+}
+
+// Done : Returns how many hosts are done, scanned or failed.
+func (summary *Summary) Done() int {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.Hosts
+}
+
+// OpenPorts : Returns how many ports were found open.
+func (summary *Summary) OpenPorts() int {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	return summary.Open
+}
+
+// Print : Writes out the totals.
+func (summary *Summary) Print(writer io.Writer) {
+	summary.mutex.Lock()
+	defer summary.mutex.Unlock()
+
+	// Hosts are only ever down when they're pinged first.
+	failed := fmt.Sprintf("%d failed", summary.Failed)
+
+	if summary.Down > 0 {
+		failed += fmt.Sprintf(", %d down", summary.Down)
+	}
+
+	fmt.Fprintf(writer, "Scanned %d hosts (%s) in %v: %d open, %d closed, %d filtered ports\n",
+		summary.Hosts, failed, time.Since(summary.Start).Round(time.Millisecond),
+		summary.Open, summary.Closed, summary.Filtered)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/add1ct3d/shellscan/scanner"
+)
+
+func TestSummary(t *testing.T) {
+	summary := NewSummary()
+
+	summary.Add([]scanner.Result{{State: scanner.Open}, {State: scanner.Closed}, {State: scanner.Filtered}})
+	summary.Add([]scanner.Result{{State: scanner.DialFailed}, {State: scanner.Filtered}})
+	summary.Fail()
+
+	var buffer bytes.Buffer
+	summary.Print(&buffer)
+
+	// Dial-failed ports count as open, and failed hosts as scanned.
+	want := regexp.MustCompile(`^Scanned 3 hosts \(1 failed\) in \d+(\.\d+)?m?s: 2 open, 1 closed, 2 filtered ports\n$`)
+
+	if !want.MatchString(buffer.String()) {
+		t.Errorf("got %q", buffer.String())
+	}
+
+	if summary.Done() != 3 || summary.OpenPorts() != 2 {
+		t.Errorf("got %d done, %d open ports, want 3 and 2", summary.Done(), summary.OpenPorts())
+	}
+
+	// Hosts that are down only show up once there are some.
+	summary.Skip()
+	buffer.Reset()
+	summary.Print(&buffer)
+
+	if !regexp.MustCompile(`^Scanned 4 hosts \(1 failed, 1 down\) in `).MatchString(buffer.String()) {
+		t.Errorf("got %q", buffer.String())
+	}
+}
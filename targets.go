@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expander turns the IPs, CIDR blocks, IP ranges and hostnames given by the
+// user into the hosts to scan. The targets are only parsed up front, the hosts
+// themselves are handed out one at a time by Walk, so that big networks don't
+// have to fit in memory.
+type Expander struct {
+	// Whether to leave out the network and broadcast addresses of IPv4 blocks,
+	// except for /31 and /32 blocks which don't have any.
+	SkipEdges bool
+
+	// Which addresses of hostnames to scan, 4 or 6 for just the ones of that
+	// family and 0 for all of them.
+	Family int
+
+	// Looks up the addresses of hostnames, net.LookupIP unless it's set.
+	Lookup func(host string) ([]net.IP, error)
+
+	// The most hosts to expand to, past which Parse gives up. Zero means
+	// there's no limit.
+	Max int
+
+	// How many hosts to pick at random out of the targets, rather than
+	// handing all of them out. It's the sample that has to stay within Max
+	// then, not the targets. Zero means every host is handed out.
+	Sample int
+
+	// Whether Walk hands the hosts out in a random order rather than the one
+	// the targets were given in.
+	Shuffle bool
+
+	// Where the sample and the order are picked with, a source seeded with
+	// the time unless it's set.
+	Rand *rand.Rand
+
+	// The hostname every address came from, for the addresses that came from
+	// one, and why the hostnames that couldn't be resolved couldn't be. Both
+	// are filled in by Parse.
+	Names map[string]string
+	Failures map[string]error
+
+	// Every target as a run of consecutive addresses, in the order they were
+	// given.
+	blocks []block
+
+	// Where the blocks of a single address are, keyed on the address, and
+	// which of the blocks have more than one, to tell whether an address was
+	// already handed out by an earlier block.
+	singles map[string]int
+	ranges []int
+
+	// The addresses never to hand out, whatever the targets are.
+	excluded []block
+
+	// How many addresses the blocks have between them, duplicates included,
+	// and the sample and the order once they've been picked.
+	total *big.Int
+	sample []net.IP
+	order *permutation
+}
+
+// block is a run of addresses, from the first to the last one, and how many
+// of them there are.
+type block struct {
+	first net.IP
+	last net.IP
+	size *big.Int
+}
+
+// Parse : Parses every one of the given targets, counting the hosts they
+// expand to without going through them. Only targets that make no sense are
+// errors, hostnames that can't be resolved are left out and recorded in the
+// Failures instead.
+func (expander *Expander) Parse(args []string) error {
+	expander.Names = map[string]string{}
+	expander.Failures = map[string]error{}
+	expander.blocks = nil
+	expander.singles = map[string]int{}
+	expander.ranges = nil
+	expander.total = new(big.Int)
+	expander.sample = nil
+	expander.order = nil
+
+	for _, arg := range args {
+		// A range of addresses, from the first to the last one. Hostnames can
+		// have dashes as well, but they don't start with an address.
+		if start, _, ok := strings.Cut(arg, "-"); ok && net.ParseIP(start) != nil {
+			first, last, err := parseRange(arg)
+
+			if err != nil {
+				return err
+			}
+
+			if err := expander.add(first, last); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// A single host, either by address or by name.
+		if !strings.Contains(arg, "/") {
+			if ip := net.ParseIP(arg); ip != nil {
+				if err := expander.add(normalize(ip), normalize(ip)); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			addresses, err := expander.resolve(arg)
+
+			if err != nil {
+				expander.Failures[arg] = err
+				continue
+			}
+
+			// Remember the name to show along with the results, keeping the
+			// first one for addresses more than one name resolves to.
+			for _, ip := range addresses {
+				if _, ok := expander.Names[ip.String()]; !ok {
+					expander.Names[ip.String()] = arg
+				}
+
+				if err := expander.add(ip, ip); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		// An IP net, which runs from its all zeros address to its all ones
+		// one.
+		_, ipnet, err := net.ParseCIDR(arg)
+
+		if err != nil {
+			return err
+		}
+
+		first, last := networkBlock(ipnet)
+
+		// Nothing answers on the network and broadcast addresses of a subnet,
+		// at least nothing we'd want to scan.
+		if ones, bits := ipnet.Mask.Size(); expander.SkipEdges && bits == 32 && ones < 31 {
+			first, last = next(first), previous(last)
+		}
+
+		if err := expander.add(first, last); err != nil {
+			return err
+		}
+	}
+
+	if expander.Max > 0 && expander.Sample > expander.Max {
+		return expander.tooMany()
+	}
+
+	return nil
+}
+
+// Exclude : Parses the IPs, CIDR blocks and IP ranges that Walk should leave
+// out, even when they're part of the targets. Excluded hosts still count
+// towards Max.
+func (expander *Expander) Exclude(args []string) error {
+	expander.excluded = nil
+
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+
+		if start, _, ok := strings.Cut(arg, "-"); ok && net.ParseIP(start) != nil {
+			first, last, err := parseRange(arg)
+
+			if err != nil {
+				return err
+			}
+
+			expander.excluded = append(expander.excluded, block{first: first, last: last})
+			continue
+		}
+
+		if ip := net.ParseIP(arg); ip != nil {
+			expander.excluded = append(expander.excluded, block{first: normalize(ip), last: normalize(ip)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(arg)
+
+		if err != nil {
+			return fmt.Errorf("Invalid exclude: %q", arg)
+		}
+
+		first, last := networkBlock(ipnet)
+		expander.excluded = append(expander.excluded, block{first: first, last: last})
+	}
+
+	return nil
+}
+
+// add : Adds a block of addresses to the targets, unless that makes too many
+// hosts. Blocks are counted in full, even if some of their hosts were given
+// before.
+func (expander *Expander) add(first net.IP, last net.IP) error {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(last), new(big.Int).SetBytes(first))
+	size.Add(size, big.NewInt(1))
+	expander.total.Add(expander.total, size)
+
+	if expander.Max > 0 && expander.Sample == 0 && expander.total.Cmp(big.NewInt(int64(expander.Max))) > 0 {
+		return expander.tooMany()
+	}
+
+	index := len(expander.blocks)
+	expander.blocks = append(expander.blocks, block{first: first, last: last, size: size})
+
+	if !first.Equal(last) {
+		expander.ranges = append(expander.ranges, index)
+	} else if _, ok := expander.singles[string(first)]; !ok {
+		expander.singles[string(first)] = index
+	}
+
+	return nil
+}
+
+// Walk : Hands every host out to yield, one at a time and in the order the
+// targets were given, until yield returns false. Hosts that show up more than
+// once, such as when a host is given on its own as well as part of a network,
+// are only handed out the first time. With a Sample, only the hosts of the
+// sample are handed out, the same ones every time. With Shuffle, the hosts are
+// handed out in a random order instead, which is the same every time too.
+func (expander *Expander) Walk(yield func(ip net.IP) bool) {
+	if expander.Sample > 0 && expander.total.Cmp(big.NewInt(int64(expander.Sample))) > 0 {
+		if expander.sample == nil {
+			expander.sample = expander.pick()
+		}
+
+		for _, ip := range expander.sample {
+			if !yield(ip) {
+				return
+			}
+		}
+
+		return
+	}
+
+	// The order only goes through the positions of the hosts, so it doesn't
+	// take any more memory than going through them in order.
+	if expander.Shuffle {
+		if expander.order == nil {
+			expander.order = newPermutation(expander.total, expander.random())
+		}
+
+		expander.order.each(func(position *big.Int) bool {
+			index, ip := expander.at(position)
+
+			return expander.seen(index, ip) || expander.isExcluded(ip) || yield(ip)
+		})
+
+		return
+	}
+
+	for index, block := range expander.blocks {
+		for ip := block.first; ; ip = next(ip) {
+			if !expander.seen(index, ip) && !expander.isExcluded(ip) && !yield(ip) {
+				return
+			}
+
+			if ip.Equal(block.last) {
+				break
+			}
+		}
+	}
+}
+
+// seen : Tells whether the address is in one of the blocks before the one at
+// index, and so was already handed out. Only the blocks themselves are looked
+// at, rather than remembering every address handed out.
+func (expander *Expander) seen(index int, ip net.IP) bool {
+	if earlier, ok := expander.singles[string(ip)]; ok && earlier < index {
+		return true
+	}
+
+	for _, earlier := range expander.ranges {
+		if earlier >= index {
+			break
+		}
+
+		if expander.blocks[earlier].contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxSampleTries is how many times over picking a host for the sample may end
+// up on one that can't be in it, a duplicate or an excluded one, before giving
+// up on a full sample.
+const maxSampleTries = 100
+
+// pick : Picks the hosts of the sample, each host of the targets being as
+// likely to be picked as any other, without going through all of them. Random
+// addresses are drawn out of all the blocks, and drawn again if they were
+// already picked, are excluded or aren't the first of their duplicates.
+func (expander *Expander) pick() []net.IP {
+	random := expander.random()
+	picked := make(map[string]bool, expander.Sample)
+	sample := make([]net.IP, 0, expander.Sample)
+
+	for tries := 0; len(sample) < expander.Sample && tries < expander.Sample * maxSampleTries; tries++ {
+		index, ip := expander.at(new(big.Int).Rand(random, expander.total))
+
+		if picked[string(ip)] || expander.seen(index, ip) || expander.isExcluded(ip) {
+			continue
+		}
+
+		picked[string(ip)] = true
+		sample = append(sample, ip)
+	}
+
+	return sample
+}
+
+// random : Returns where to pick the sample and the order with.
+func (expander *Expander) random() *rand.Rand {
+	if expander.Rand == nil {
+		expander.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	return expander.Rand
+}
+
+// permutation goes through every position below a size once, in an order
+// picked at random, without keeping track of the positions it went through.
+// It's a linear congruential generator over the smallest power of two the
+// positions fit in, which goes through every number below it once before
+// coming back to the first, skipping the ones past the positions.
+type permutation struct {
+	size *big.Int
+	mask *big.Int
+	multiplier *big.Int
+	increment *big.Int
+	start *big.Int
+}
+
+// newPermutation : Picks an order of the positions below size.
+func newPermutation(size *big.Int, random *rand.Rand) *permutation {
+	bits := 0
+
+	if size.Sign() > 0 {
+		bits = new(big.Int).Sub(size, big.NewInt(1)).BitLen()
+	}
+
+	modulus := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+
+	// The generator only goes through every number with a multiplier that's
+	// one more than a multiple of four and an odd increment.
+	multiplier := new(big.Int).Rand(random, modulus)
+	multiplier.Lsh(multiplier, 2).SetBit(multiplier, 0, 1)
+
+	increment := new(big.Int).Rand(random, modulus)
+	increment.SetBit(increment, 0, 1)
+
+	return &permutation{
+		size: size,
+		mask: new(big.Int).Sub(modulus, big.NewInt(1)),
+		multiplier: multiplier,
+		increment: increment,
+		start: new(big.Int).Rand(random, modulus),
+	}
+}
+
+// each : Hands every position out to yield, until yield returns false. The
+// position is only valid until yield returns.
+func (order *permutation) each(yield func(position *big.Int) bool) {
+	position := new(big.Int).And(order.start, order.mask)
+
+	for steps := new(big.Int).Add(order.mask, big.NewInt(1)); steps.Sign() > 0; steps.Sub(steps, big.NewInt(1)) {
+		if position.Cmp(order.size) < 0 && !yield(position) {
+			return
+		}
+
+		position.Mul(position, order.multiplier).Add(position, order.increment).And(position, order.mask)
+	}
+}
+
+// at : Returns the address at the given position of all the blocks one after
+// the other, along with the index of its block.
+func (expander *Expander) at(position *big.Int) (int, net.IP) {
+	offset := new(big.Int).Set(position)
+
+	for index, block := range expander.blocks {
+		if offset.Cmp(block.size) < 0 {
+			address := offset.Add(offset, new(big.Int).SetBytes(block.first))
+
+			return index, net.IP(address.FillBytes(make([]byte, len(block.first))))
+		}
+
+		offset.Sub(offset, block.size)
+	}
+
+	return -1, nil
+}
+
+// isExcluded : Tells whether the address is one of those to leave out.
+func (expander *Expander) isExcluded(ip net.IP) bool {
+	for _, block := range expander.excluded {
+		if block.contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contains : Tells whether the address is part of the block.
+func (block block) contains(ip net.IP) bool {
+	return len(block.first) == len(ip) && bytes.Compare(block.first, ip) <= 0 && bytes.Compare(ip, block.last) <= 0
+}
+
+// networkBlock : Returns the first and last address of an IP net, the all
+// zeros and all ones ones.
+func networkBlock(ipnet *net.IPNet) (net.IP, net.IP) {
+	first := normalize(ipnet.IP).Mask(ipnet.Mask)
+	last := append(net.IP(nil), first...)
+
+	for j := range last {
+		last[j] |= ^ipnet.Mask[j]
+	}
+
+	return first, last
+}
+
+// tooMany : Returns the error for expanding to more than Max hosts.
+func (expander *Expander) tooMany() error {
+	return fmt.Errorf("Targets expand to more than %d hosts", expander.Max)
+}
+
+// resolve : Looks up the addresses of a hostname, keeping the ones of the
+// address family we're after.
+func (expander *Expander) resolve(host string) ([]net.IP, error) {
+	lookup := expander.Lookup
+
+	if lookup == nil {
+		lookup = net.LookupIP
+	}
+
+	addresses, err := lookup(host)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to resolve %q: %v", host, err)
+	}
+
+	hosts := []net.IP{}
+
+	for _, ip := range addresses {
+		ip = normalize(ip)
+
+		if expander.Family == 4 && len(ip) != net.IPv4len || expander.Family == 6 && len(ip) != net.IPv6len {
+			continue
+		}
+
+		hosts = append(hosts, ip)
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("No IPv%d address for %q", expander.Family, host)
+	}
+
+	return hosts, nil
+}
+
+// parseRange : Parses a range of addresses, either written out in full like
+// 10.0.0.1-10.0.0.254 or with just the last octet of the end like 10.0.0.1-254.
+func parseRange(arg string) (net.IP, net.IP, error) {
+	parts := strings.SplitN(arg, "-", 2)
+	first := net.ParseIP(parts[0])
+
+	if first == nil {
+		return nil, nil, fmt.Errorf("Invalid IP range: %q", arg)
+	}
+
+	first = normalize(first)
+	last := net.ParseIP(parts[1])
+
+	// The short form only gives the last octet of an IPv4 address.
+	if last == nil && len(first) == net.IPv4len {
+		if octet, err := strconv.ParseUint(parts[1], 10, 8); err == nil {
+			last = net.IPv4(first[0], first[1], first[2], byte(octet))
+		}
+	}
+
+	if last == nil {
+		return nil, nil, fmt.Errorf("Invalid IP range: %q", arg)
+	}
+
+	last = normalize(last)
+
+	if len(first) != len(last) {
+		return nil, nil, fmt.Errorf("IP range mixes IPv4 and IPv6: %q", arg)
+	}
+
+	if bytes.Compare(first, last) > 0 {
+		return nil, nil, fmt.Errorf("IP range ends before it starts: %q", arg)
+	}
+
+	return first, last, nil
+}
+
+// readTargets : Reads one target per line, skipping blank lines and comments
+// starting with #. The targets are expanded just like the ones on the command
+// line.
+func readTargets(reader io.Reader) ([]string, error) {
+	args := []string{}
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		args = append(args, line)
+	}
+
+	return args, scanner.Err()
+}
+
+// readTargetFile : Reads the targets from a file, or from stdin if the path is
+// "-".
+func readTargetFile(path string) ([]string, error) {
+	if path == "-" {
+		return readTargets(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return readTargets(file)
+}
+
+// normalize : Returns the 4 byte form of IPv4 addresses and the 16 byte form of
+// IPv6 ones, so that the address family can be told from the length.
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip.To16()
+}
+
+// next : Returns the address following ip, leaving ip itself untouched. The
+// address wraps around to all zeros after the last one.
+func next(ip net.IP) net.IP {
+	ip = append(net.IP(nil), ip...)
+
+	for j := len(ip) - 1; j >= 0; j-- {
+		ip[j]++
+
+		if ip[j] > 0 {
+			break
+		}
+	}
+
+	return ip
+}
+
+// previous : Returns the address before ip, leaving ip itself untouched.
+func previous(ip net.IP) net.IP {
+	ip = append(net.IP(nil), ip...)
+
+	for j := len(ip) - 1; j >= 0; j-- {
+		ip[j]--
+
+		if ip[j] < 0xff {
+			break
+		}
+	}
+
+	return ip
+}
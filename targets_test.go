@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// walk : Returns the hosts the targets expand to, in the order Walk hands them
+// out.
+func walk(expander *Expander) []string {
+	hosts := []string{}
+
+	expander.Walk(func(ip net.IP) bool {
+		hosts = append(hosts, ip.String())
+		return true
+	})
+
+	return hosts
+}
+
+// addresses : Returns n consecutive addresses, starting with first.
+func addresses(first string, n int) []string {
+	hosts := make([]string, 0, n)
+
+	for ip := normalize(net.ParseIP(first)); len(hosts) < n; ip = next(ip) {
+		hosts = append(hosts, ip.String())
+	}
+
+	return hosts
+}
+
+func TestExpand(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want []string
+		err bool
+	}{
+		{[]string{"10.0.0.0/30"}, addresses("10.0.0.0", 4), false},
+		{[]string{"10.0.0.0/24"}, addresses("10.0.0.0", 256), false},
+		{[]string{"10.0.0.5"}, []string{"10.0.0.5"}, false},
+		{[]string{"10.0.0.5/32"}, []string{"10.0.0.5"}, false},
+		{[]string{"fd00::1"}, []string{"fd00::1"}, false},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"10.0.0.250-10.0.1.2"}, addresses("10.0.0.250", 9), false},
+		{[]string{"10.0.0.10-20"}, addresses("10.0.0.10", 11), false},
+		{[]string{"10.0.0.7-10.0.0.7"}, []string{"10.0.0.7"}, false},
+		{[]string{"fd00::fe-fd00::101"}, addresses("fd00::fe", 4), false},
+		{[]string{"10.0.0.20-10.0.0.10"}, nil, true},
+		{[]string{"10.0.0.20-10"}, nil, true},
+		{[]string{"10.0.0.1-300"}, nil, true},
+		{[]string{"10.0.0.1-fd00::1"}, nil, true},
+		{[]string{"10.0.0.0/30", "10.0.0.2", "10.0.0.9"}, append(addresses("10.0.0.0", 4), "10.0.0.9"), false},
+		{[]string{"10.0.0.2", "10.0.0.0/30", "10.0.0.2"}, []string{"10.0.0.2", "10.0.0.0", "10.0.0.1", "10.0.0.3"}, false},
+		{[]string{"10.0.0.0/30", "10.0.0.2-10.0.0.5"}, addresses("10.0.0.0", 6), false},
+	} {
+		expander := &Expander{}
+		err := expander.Parse(c.args)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%v: got no error", c.args)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", c.args, err)
+			continue
+		}
+
+		if hosts := walk(expander); !slices.Equal(hosts, c.want) {
+			t.Errorf("%v: got hosts %v, want %v", c.args, hosts, c.want)
+		}
+	}
+}
+
+func TestExpandSkipEdges(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"10.0.0.0/24"}, addresses("10.0.0.1", 254)},
+		{[]string{"10.0.0.0/30"}, []string{"10.0.0.1", "10.0.0.2"}},
+		{[]string{"10.0.0.4/31"}, []string{"10.0.0.4", "10.0.0.5"}},
+		{[]string{"10.0.0.4/32"}, []string{"10.0.0.4"}},
+		{[]string{"10.0.0.0", "10.0.0.0-10.0.0.1"}, []string{"10.0.0.0", "10.0.0.1"}},
+		{[]string{"fd00::/126"}, addresses("fd00::", 4)},
+	} {
+		expander := &Expander{SkipEdges: true}
+
+		if err := expander.Parse(c.args); err != nil {
+			t.Errorf("%v: %v", c.args, err)
+			continue
+		}
+
+		if hosts := walk(expander); !slices.Equal(hosts, c.want) {
+			t.Errorf("%v: got hosts %v, want %v", c.args, hosts, c.want)
+		}
+	}
+}
+
+func TestExpandMax(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		max int
+		err bool
+	}{
+		{[]string{"10.0.0.0/8"}, 65536, true},
+		{[]string{"0.0.0.0/0"}, 65536, true},
+		{[]string{"10.0.0.0/16"}, 65536, false},
+		{[]string{"10.0.0.0/16", "10.1.0.1"}, 65536, true},
+		{[]string{"10.0.0.0-10.0.0.255"}, 255, true},
+		{[]string{"10.0.0.0/8"}, 0, false},
+	} {
+		expander := &Expander{Max: c.max}
+
+		if err := expander.Parse(c.args); (err != nil) != c.err {
+			t.Errorf("%v under %d: got error %v", c.args, c.max, err)
+		}
+	}
+}
+
+// TestWalkLarge goes through a /12 one host at a time, keeping just the first
+// and last of them and how many there were.
+func TestWalkLarge(t *testing.T) {
+	expander := &Expander{}
+
+	if err := expander.Parse([]string{"10.16.0.0/12"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var first, last net.IP
+	count := 0
+
+	expander.Walk(func(ip net.IP) bool {
+		if first == nil {
+			first = ip
+		}
+
+		last = ip
+		count++
+
+		return true
+	})
+
+	if first.String() != "10.16.0.0" || last.String() != "10.31.255.255" || count != 1 << 20 {
+		t.Errorf("got %d hosts from %v to %v", count, first, last)
+	}
+
+	// Walking stops as soon as yield says so.
+	count = 0
+
+	expander.Walk(func(ip net.IP) bool {
+		count++
+		return count < 10
+	})
+
+	if count != 10 {
+		t.Errorf("got %d hosts after stopping at 10", count)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	expander := &Expander{}
+
+	if err := expander.Parse([]string{"10.0.0.0/24", "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A host given on its own is still left out when it's excluded.
+	if err := expander.Exclude([]string{"10.0.0.0/28", " 10.0.0.100-10.0.0.199", "10.0.0.255"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(addresses("10.0.0.16", 84), addresses("10.0.0.200", 55)...)
+
+	if hosts := walk(expander); !slices.Equal(hosts, want) {
+		t.Errorf("got hosts %v, want %v", hosts, want)
+	}
+
+	for _, arg := range []string{"10.0.0.0/33", "nowhere.example.com", "10.0.0.9-10.0.0.1"} {
+		if err := expander.Exclude([]string{arg}); err == nil {
+			t.Errorf("%s: got no error", arg)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	expander := &Expander{Sample: 50, Rand: rand.New(rand.NewSource(1))}
+
+	if err := expander.Parse([]string{"10.0.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := expander.Exclude([]string{"10.0.0.0/17"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, block, _ := net.ParseCIDR("10.0.128.0/17")
+	hosts := walk(expander)
+	distinct := map[string]bool{}
+
+	for _, host := range hosts {
+		if !block.Contains(net.ParseIP(host)) {
+			t.Errorf("got %s, which isn't in %v", host, block)
+		}
+
+		distinct[host] = true
+	}
+
+	if len(hosts) != 50 || len(distinct) != 50 {
+		t.Errorf("got %d hosts, %d of them distinct, want 50", len(hosts), len(distinct))
+	}
+
+	// The sample stays the same every time the targets are walked.
+	if again := walk(expander); !slices.Equal(again, hosts) {
+		t.Errorf("got %v the second time, want %v", again, hosts)
+	}
+
+	// A sample bigger than the targets is all of them.
+	expander = &Expander{Sample: 10}
+
+	if err := expander.Parse([]string{"10.0.0.0/30"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if hosts := walk(expander); !slices.Equal(hosts, addresses("10.0.0.0", 4)) {
+		t.Errorf("got hosts %v, want the whole /30", hosts)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	shuffled := func(seed int64) *Expander {
+		expander := &Expander{Shuffle: true, Rand: rand.New(rand.NewSource(seed))}
+
+		// Overlapping targets, whose sizes add up to no power of two.
+		if err := expander.Parse([]string{"10.0.0.0/24", "10.0.1.0-10.0.1.99", "10.0.0.7", "10.0.2.1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := expander.Exclude([]string{"10.0.0.128/25"}); err != nil {
+			t.Fatal(err)
+		}
+
+		return expander
+	}
+
+	expander := shuffled(42)
+	hosts := walk(expander)
+
+	// Every host is there once, just not in order.
+	want := append(append(addresses("10.0.0.0", 128), addresses("10.0.1.0", 100)...), "10.0.2.1")
+	sorted := slices.Clone(hosts)
+
+	slices.SortFunc(sorted, func(a, b string) int {
+		return slices.Compare(normalize(net.ParseIP(a)), normalize(net.ParseIP(b)))
+	})
+
+	if !slices.Equal(sorted, want) {
+		t.Errorf("got hosts %v, want %v", sorted, want)
+	}
+
+	if slices.Equal(hosts, want) {
+		t.Error("the hosts weren't shuffled")
+	}
+
+	// The order stays the same every time the targets are walked, and with
+	// the same seed, but not with another one.
+	if again := walk(expander); !slices.Equal(again, hosts) {
+		t.Errorf("got %v the second time, want %v", again, hosts)
+	}
+
+	if same := walk(shuffled(42)); !slices.Equal(same, hosts) {
+		t.Errorf("got %v with the same seed, want %v", same, hosts)
+	}
+
+	if other := walk(shuffled(43)); slices.Equal(other, hosts) {
+		t.Error("seeds 42 and 43 gave the same order")
+	}
+
+	// Big networks are shuffled without going through them first.
+	expander = &Expander{Shuffle: true, Rand: rand.New(rand.NewSource(1))}
+
+	if err := expander.Parse([]string{"2001:db8::/32"}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+
+	expander.Walk(func(ip net.IP) bool {
+		count++
+		return count < 10
+	})
+
+	if count != 10 {
+		t.Errorf("got %d hosts after stopping at 10", count)
+	}
+}
+
+func TestParseHostnames(t *testing.T) {
+	expander := &Expander{
+		Family: 4,
+		Lookup: func(host string) ([]net.IP, error) {
+			switch host {
+			case "git.example.com":
+				return []net.IP{net.ParseIP("93.184.215.14"), net.ParseIP("2606:2800:21f:cb07:6820:80da:af6b:8b2c")}, nil
+			case "www.example.com":
+				return []net.IP{net.ParseIP("93.184.215.14")}, nil
+			}
+
+			return nil, errors.New("no such host")
+		},
+	}
+
+	if err := expander.Parse([]string{"git.example.com", "www.example.com", "nowhere.example.com", "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := walk(expander)
+
+	// Just the IPv4 address, once, and the address given as is.
+	if !slices.Equal(hosts, []string{"93.184.215.14", "10.0.0.1"}) {
+		t.Errorf("got hosts %v", hosts)
+	}
+
+	// The first name an address came from is the one it's shown with.
+	if name := expander.Names["93.184.215.14"]; name != "git.example.com" || len(expander.Names) != 1 {
+		t.Errorf("got names %v", expander.Names)
+	}
+
+	// A name that doesn't resolve fails on its own.
+	if err := expander.Failures["nowhere.example.com"]; err == nil || len(expander.Failures) != 1 {
+		t.Errorf("got failures %v", expander.Failures)
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	input := "10.0.0.1\n\n# The lab\n  192.168.1.0/24  \n\t#10.0.0.2\n10.0.1.1-10.0.1.5\r\ngit.example.com"
+
+	targets, err := readTargets(strings.NewReader(input))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.1", "192.168.1.0/24", "10.0.1.1-10.0.1.5", "git.example.com"}
+
+	if !slices.Equal(targets, want) {
+		t.Errorf("got %q, want %q", targets, want)
+	}
+
+	if _, err := readTargetFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}